@@ -39,10 +39,23 @@ func NewForKeys(nkeys int, bitsPerKey uint32, k uint8) *Filter {
 }
 
 func (f *Filter) Add(key []byte) {
+	f.AddHash(Hash(key))
+}
+
+// KeyHash is what the filter keeps of a key.
+type KeyHash struct{ h1, h2 uint64 }
+
+// Hash returns the hash Add derives from key. A builder that only learns the
+// filter size at the end can keep these instead of the keys.
+func Hash(key []byte) KeyHash {
 	h1, h2 := hash2(key)
+	return KeyHash{h1, h2}
+}
+
+func (f *Filter) AddHash(kh KeyHash) {
 	for i := uint8(0); i < f.k; i++ {
 		// double hashing: h_i = h1 + i*h2
-		h := h1 + uint64(i)*h2
+		h := kh.h1 + uint64(i)*kh.h2
 		f.setBit(uint32(h % uint64(f.bits)))
 	}
 }
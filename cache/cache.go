@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// Cache is a sharded LRU cache of SSTable blocks keyed by (table ID, block
+// offset). Its capacity is a byte budget over the cached values; each shard
+// gets an equal part and evicts independently, so lookups on different
+// shards don't contend.
+//
+// Cached values are shared between readers and must not be modified.
+type Cache struct {
+	shards [numShards]shard
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+const numShards = 16
+
+type key struct {
+	table  uint64
+	offset uint64
+}
+
+type entry struct {
+	key   key
+	value []byte
+}
+
+type shard struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	lru      list.List // front is most recently used
+	items    map[key]*list.Element
+}
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits     uint64
+	Misses   uint64
+	Size     int64 // bytes currently cached
+	Capacity int64
+}
+
+// New returns a cache holding up to capacity bytes of blocks.
+func New(capacity int64) *Cache {
+	c := &Cache{}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.capacity = capacity / numShards
+		s.items = make(map[key]*list.Element)
+	}
+	return c
+}
+
+func (c *Cache) shard(k key) *shard {
+	h := k.table*0x9e3779b97f4a7c15 ^ k.offset*0xbf58476d1ce4e5b9
+	return &c.shards[(h>>32)%numShards]
+}
+
+// Get returns the block cached for (table, offset).
+func (c *Cache) Get(table, offset uint64) ([]byte, bool) {
+	k := key{table, offset}
+	s := c.shard(k)
+	s.mu.Lock()
+	e, ok := s.items[k]
+	if ok {
+		s.lru.MoveToFront(e)
+	}
+	s.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return e.Value.(*entry).value, true
+}
+
+// Set caches value for (table, offset), evicting least recently used blocks
+// of the same shard as needed. Values larger than a shard are not cached.
+func (c *Cache) Set(table, offset uint64, value []byte) {
+	k := key{table, offset}
+	s := c.shard(k)
+	charge := int64(len(value))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if charge > s.capacity {
+		return
+	}
+	if e, ok := s.items[k]; ok {
+		s.remove(e)
+	}
+	s.items[k] = s.lru.PushFront(&entry{key: k, value: value})
+	s.size += charge
+	for s.size > s.capacity {
+		s.remove(s.lru.Back())
+	}
+}
+
+// EvictTable drops every block of table, e.g. once its file is deleted.
+func (c *Cache) EvictTable(table uint64) {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		for k, e := range s.items {
+			if k.table == table {
+				s.remove(e)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *shard) remove(e *list.Element) {
+	ent := s.lru.Remove(e).(*entry)
+	delete(s.items, ent.key)
+	s.size -= int64(len(ent.value))
+}
+
+func (c *Cache) Stats() Stats {
+	st := Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		st.Size += s.size
+		st.Capacity += s.capacity
+		s.mu.Unlock()
+	}
+	return st
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"container/list"
+	"os"
+	"sync"
+)
+
+// Files keeps SSTable files open between reads, keyed by table ID, so a
+// lookup doesn't pay open/close for every table it probes. At most max files
+// stay cached; the least recently used one is dropped beyond that. A dropped
+// file that is still referenced is closed once its last user releases it, so
+// the number of open files can briefly exceed max while iterators pin them.
+type Files struct {
+	mu    sync.Mutex
+	max   int
+	lru   list.List // of *openFile, front is most recently used
+	items map[uint64]*list.Element
+}
+
+type openFile struct {
+	id      uint64
+	f       *os.File
+	refs    int
+	evicted bool
+}
+
+// NewFiles returns a cache holding up to max open files.
+func NewFiles(max int) *Files {
+	return &Files{
+		max:   max,
+		items: make(map[uint64]*list.Element),
+	}
+}
+
+// Acquire returns the open file for table id, opening path if needed. The
+// file must be handed back with release once the caller is done reading;
+// callers must not close it themselves.
+func (c *Files) Acquire(id uint64, path string) (f *os.File, release func(), err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var of *openFile
+	if e, ok := c.items[id]; ok {
+		c.lru.MoveToFront(e)
+		of = e.Value.(*openFile)
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		of = &openFile{id: id, f: f}
+		c.items[id] = c.lru.PushFront(of)
+		for c.lru.Len() > c.max && c.lru.Len() > 1 {
+			c.evictLocked(c.lru.Back())
+		}
+	}
+	of.refs++
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			of.refs--
+			if of.refs == 0 && of.evicted {
+				_ = of.f.Close()
+			}
+		})
+	}
+	return of.f, release, nil
+}
+
+// Evict drops table id from the cache, e.g. once its file is deleted.
+func (c *Files) Evict(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[id]; ok {
+		c.evictLocked(e)
+	}
+}
+
+func (c *Files) evictLocked(e *list.Element) {
+	of := c.lru.Remove(e).(*openFile)
+	delete(c.items, of.id)
+	of.evicted = true
+	if of.refs == 0 {
+		_ = of.f.Close()
+	}
+}
+
+// Close closes every cached file that isn't in use; the rest are closed as
+// they are released.
+func (c *Files) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.evictLocked(c.lru.Back())
+	}
+}
+
+// Len returns the number of cached files.
+func (c *Files) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
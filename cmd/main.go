@@ -3,9 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/ChinmayNoob/lsm-go/db"
+	"github.com/ChinmayNoob/lsm-go/manifest"
+	"github.com/ChinmayNoob/lsm-go/merge"
+	"github.com/ChinmayNoob/lsm-go/sstable"
 )
 
 func main() {
@@ -23,6 +31,12 @@ func main() {
 	maxSST := fs.Int("maxsst", 0, "MaxSSTables before compaction (0 disables)")
 	syncOnWrite := fs.Bool("sync", true, "fsync WAL on each write")
 	verbose := fs.Bool("verbose", false, "show Bloom filter behavior and SSTable checks")
+	cacheBytes := fs.Int64("cache", 8<<20, "block cache capacity in bytes (0 disables)")
+	maxOpen := fs.Int("maxopen", 500, "SSTable files kept open (0 opens per read)")
+	compression := fs.String("compression", "lz", "SSTable block codec: none, flate or lz")
+	prefixLen := fs.Int("prefixlen", 0, "key prefix length added to SSTable Bloom filters (0 disables)")
+	ttl := fs.Duration("ttl", 0, "put: expire the value after this long (0 never expires)")
+	mergeOp := fs.String("merge", "", "merge operator: add (uint64 counters) or append (comma-separated)")
 
 	if err := fs.Parse(os.Args[2:]); err != nil {
 		os.Exit(2)
@@ -35,6 +49,75 @@ func main() {
 	opts.MaxSSTTables = *maxSST
 	opts.SyncOnWrite = *syncOnWrite
 	opts.Verbose = *verbose
+	opts.BlockCacheBytes = *cacheBytes
+	opts.MaxOpenFiles = *maxOpen
+	c, err := sstable.ParseCompression(*compression)
+	if err != nil {
+		fatal(err)
+	}
+	opts.Compression = c
+	if *prefixLen > 0 {
+		opts.PrefixExtractor = sstable.FixedPrefix(*prefixLen)
+	}
+	// With -merge add, values are uint64 counters given and printed in
+	// decimal.
+	encode := func(s string) []byte { return []byte(s) }
+	format := func(v []byte) string { return string(v) }
+	switch *mergeOp {
+	case "":
+	case "add":
+		opts.MergeOperator = merge.Uint64Add()
+		encode = func(s string) []byte {
+			n, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				fatal(err)
+			}
+			return merge.EncodeUint64(n)
+		}
+		format = func(v []byte) string {
+			if n, err := merge.DecodeUint64(v); err == nil {
+				return strconv.FormatUint(n, 10)
+			}
+			return string(v)
+		}
+	case "append":
+		opts.MergeOperator = merge.Append([]byte(","))
+	default:
+		fatal(fmt.Errorf("unknown merge operator %q", *mergeOp))
+	}
+
+	// restore creates the DB directory from a backup, before anything opens it.
+	if cmd == "restore" {
+		if len(args) != 1 {
+			usage()
+			os.Exit(2)
+		}
+		if err := restoreBackup(args[0], *dir); err != nil {
+			fatal(err)
+		}
+		// Opening checks that the restored directory is a usable DB.
+		d, err := db.Open(opts)
+		if err != nil {
+			fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			fatal(err)
+		}
+		fmt.Println("ok")
+		return
+	}
+
+	// dump reads a table file directly and needs no DB.
+	if cmd == "dump" {
+		if len(args) != 1 {
+			usage()
+			os.Exit(2)
+		}
+		if err := dumpTable(args[0]); err != nil {
+			fatal(err)
+		}
+		return
+	}
 
 	d, err := db.Open(opts)
 	if err != nil {
@@ -48,7 +131,20 @@ func main() {
 			usage()
 			os.Exit(2)
 		}
-		if err := d.Put([]byte(args[0]), []byte(args[1])); err != nil {
+		put := d.Put
+		if *ttl > 0 {
+			put = func(key, value []byte) error { return d.PutWithTTL(key, value, *ttl) }
+		}
+		if err := put([]byte(args[0]), encode(args[1])); err != nil {
+			fatal(err)
+		}
+		fmt.Println("ok")
+	case "merge":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if err := d.Merge([]byte(args[0]), encode(args[1])); err != nil {
 			fatal(err)
 		}
 		fmt.Println("ok")
@@ -61,11 +157,15 @@ func main() {
 		if err != nil {
 			fatal(err)
 		}
+		if *verbose {
+			st := d.BlockCacheStats()
+			fmt.Fprintf(os.Stderr, "[cache] hits=%d misses=%d size=%d/%d bytes\n", st.Hits, st.Misses, st.Size, st.Capacity)
+		}
 		if !ok {
 			fmt.Println("(not found)")
 			os.Exit(1)
 		}
-		fmt.Println(string(v))
+		fmt.Println(format(v))
 	case "del":
 		if len(args) != 1 {
 			usage()
@@ -75,17 +175,179 @@ func main() {
 			fatal(err)
 		}
 		fmt.Println("ok")
+	case "delrange":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if err := d.DeleteRange([]byte(args[0]), []byte(args[1])); err != nil {
+			fatal(err)
+		}
+		fmt.Println("ok")
+	case "ingest":
+		if len(args) == 0 {
+			usage()
+			os.Exit(2)
+		}
+		if err := d.IngestExternalFiles(args); err != nil {
+			fatal(err)
+		}
+		fmt.Println("ok")
+	case "backup":
+		if len(args) != 1 {
+			usage()
+			os.Exit(2)
+		}
+		if err := d.Checkpoint(args[0]); err != nil {
+			fatal(err)
+		}
+		fmt.Println("ok")
+	case "scan":
+		if len(args) > 2 {
+			usage()
+			os.Exit(2)
+		}
+		var lower, upper []byte
+		if len(args) > 0 && args[0] != "" {
+			lower = []byte(args[0])
+		}
+		if len(args) > 1 && args[1] != "" {
+			upper = []byte(args[1])
+		}
+		it, err := d.NewIterator(lower, upper)
+		if err != nil {
+			fatal(err)
+		}
+		printAll(it, format)
+	case "prefix":
+		if len(args) != 1 {
+			usage()
+			os.Exit(2)
+		}
+		it, err := d.NewPrefixIterator([]byte(args[0]))
+		if err != nil {
+			fatal(err)
+		}
+		printAll(it, format)
 	default:
 		usage()
 		os.Exit(2)
 	}
 }
 
+// printAll prints every key and value of it, then closes it.
+func printAll(it *db.Iterator, format func([]byte) string) {
+	for it.First(); it.Valid(); it.Next() {
+		fmt.Printf("%s\t%s\n", it.Key(), format(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		fatal(err)
+	}
+	if err := it.Close(); err != nil {
+		fatal(err)
+	}
+}
+
+// restoreBackup copies a directory written by backup to dir. Everything is
+// copied rather than linked, since the restored DB appends to its WALs.
+func restoreBackup(backup, dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("restore: %s already exists", dir)
+	}
+	if _, err := os.Stat(filepath.Join(backup, manifest.CurrentFilename)); err != nil {
+		return fmt.Errorf("restore: %s is not a backup: %w", backup, err)
+	}
+	err := filepath.WalkDir(backup, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(backup, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, rel)
+		if e.IsDir() {
+			return os.MkdirAll(dst, 0o755)
+		}
+		return copyFile(path, dst)
+	})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+	}
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// dumpTable prints every entry of an SSTable file, versions newest first,
+// then its range tombstones.
+func dumpTable(path string) error {
+	t, err := sstable.Open(path, 0, sstable.ReaderOptions{})
+	if err != nil {
+		return err
+	}
+	it, err := t.NewIterator()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = it.Close() }()
+	for it.First(); it.Valid(); it.Next() {
+		r := it.Record()
+		if r.Tombstone {
+			fmt.Printf("%s\t@%d\t(deleted)\n", r.Key, r.Seq)
+			continue
+		}
+		if r.Merge {
+			fmt.Printf("%s\t@%d\t%s\t(merge)\n", r.Key, r.Seq, r.Value)
+			continue
+		}
+		if r.ExpiresAt != 0 {
+			fmt.Printf("%s\t@%d\t%s\t(expires %s)\n", r.Key, r.Seq, r.Value, time.Unix(0, r.ExpiresAt).Format(time.RFC3339))
+			continue
+		}
+		fmt.Printf("%s\t@%d\t%s\n", r.Key, r.Seq, r.Value)
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	for _, rd := range t.RangeDels().Tombstones() {
+		fmt.Printf("[%s, %s)\t@%d\t(range deleted)\n", rd.Start, rd.End, rd.Seq)
+	}
+	return nil
+}
+
 func usage() {
 	fmt.Fprintln(os.Stderr, "Usage:")
 	fmt.Fprintln(os.Stderr, "  lsm-go [flags] put <key> <value>")
+	fmt.Fprintln(os.Stderr, "  lsm-go [flags] merge <key> <operand>  (needs -merge)")
 	fmt.Fprintln(os.Stderr, "  lsm-go [flags] get <key>")
 	fmt.Fprintln(os.Stderr, "  lsm-go [flags] del <key>")
+	fmt.Fprintln(os.Stderr, "  lsm-go [flags] delrange <start> <end>")
+	fmt.Fprintln(os.Stderr, "  lsm-go [flags] scan [lower] [upper]")
+	fmt.Fprintln(os.Stderr, "  lsm-go [flags] prefix <prefix>")
+	fmt.Fprintln(os.Stderr, "  lsm-go [flags] ingest <file.sst>...")
+	fmt.Fprintln(os.Stderr, "  lsm-go [flags] backup <backup-dir>")
+	fmt.Fprintln(os.Stderr, "  lsm-go [flags] restore <backup-dir>  (into -dir, which must not exist)")
+	fmt.Fprintln(os.Stderr, "  lsm-go dump <file.sst>")
 	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "Flags:")
 	fmt.Fprintln(os.Stderr, "  -dir     DB directory (default: data)")
@@ -93,6 +355,12 @@ func usage() {
 	fmt.Fprintln(os.Stderr, "  -maxsst  Max SSTables before compaction (0 disables)")
 	fmt.Fprintln(os.Stderr, "  -sync    fsync WAL on each write (default: true)")
 	fmt.Fprintln(os.Stderr, "  -verbose show Bloom filter behavior (skipped SSTables)")
+	fmt.Fprintln(os.Stderr, "  -cache   block cache capacity in bytes (0 disables, default: 8 MiB)")
+	fmt.Fprintln(os.Stderr, "  -maxopen SSTable files kept open (0 opens per read, default: 500)")
+	fmt.Fprintln(os.Stderr, "  -compression SSTable block codec: none, flate or lz (default: lz)")
+	fmt.Fprintln(os.Stderr, "  -prefixlen key prefix length added to SSTable Bloom filters (0 disables)")
+	fmt.Fprintln(os.Stderr, "  -ttl     put: expire the value after this long, e.g. 30s (0 never expires)")
+	fmt.Fprintln(os.Stderr, "  -merge   merge operator: add (uint64 counters, in decimal) or append (comma-separated)")
 }
 
 func fatal(err error) {
@@ -1,286 +1,394 @@
 package compaction
 
 import (
-	"bufio"
 	"bytes"
-	"container/heap"
-	"encoding/binary"
-	"errors"
-	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 
+	"github.com/ChinmayNoob/lsm-go/iterator"
 	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/merge"
+	"github.com/ChinmayNoob/lsm-go/rangedel"
 	"github.com/ChinmayNoob/lsm-go/sstable"
 )
 
-// Run is a very simple compaction:
-// - open all input tables
-// - do a k-way merge by key, picking the highest Seq per key
-// - write to a new SSTable (tmp + rename)
-// - delete old SSTables
+// Config describes how compaction outputs are written.
+type Config struct {
+	Dir string
+	// SmallestSnapshot is the oldest sequence number a reader may still ask
+	// for (the last sequence if there are no snapshots).
+	SmallestSnapshot uint64
+	// NewestSnapshot is the sequence number of the newest live snapshot,
+	// 0 if there are none. Filter only sees versions newer than it.
+	NewestSnapshot uint64
+	// TargetFileSize starts a new output once the current one reaches
+	// about this many bytes on disk. Zero writes a single output.
+	TargetFileSize int64
+	Compression    sstable.Compression
+	Reader         sstable.ReaderOptions
+	// PrefixExtractor, if set, adds key prefixes to the outputs' filters.
+	PrefixExtractor sstable.PrefixExtractor
+	// Level is the level the outputs go to.
+	Level int
+	// Filter, if set, decides on the newest value of each key that no
+	// snapshot can read.
+	Filter Filter
+	// MergeOperator folds merge operands into the values they apply to.
+	// Without one they are kept as they are.
+	MergeOperator merge.Operator
+	// Now is the time, in Unix nanoseconds, against which values written
+	// with a TTL count as expired.
+	Now int64
+	// NewFileNum allocates the ID of each output table.
+	NewFileNum func() uint64
+	// Below are the levels under the output level, each sorted by key. They
+	// only hold data older than the inputs: a tombstone none of them
+	// overlaps has nothing left to hide once no snapshot predates it, so it
+	// is dropped.
+	Below [][]*sstable.Table
+}
+
+// Stats describes what one Run did.
+type Stats struct {
+	// TombstonesDropped counts the point tombstones left out of the
+	// outputs, RangeTombstonesDropped the range tombstones and
+	// ExpiredDropped the values whose TTL had run out.
+	TombstonesDropped      int
+	RangeTombstonesDropped int
+	ExpiredDropped         int
+	// MergeOperandsFolded counts the merge operands combined into a value
+	// or into another operand.
+	MergeOperandsFolded int
+	// FilterKept, FilterRemoved and FilterChanged count Config.Filter's
+	// decisions.
+	FilterKept    int
+	FilterRemoved int
+	FilterChanged int
+}
+
+// Run merges the input tables into new ones:
+// - do a k-way merge by key
+// - keep the newest version per key, plus older ones live snapshots can read
+// - stream them into sorted outputs of about TargetFileSize (tmp + rename)
 //
-// Tombstones are preserved.
-func Run(sstDir string, inputs []*sstable.Table, outputID uint64) (*sstable.Table, error) {
+// All versions of a key end up in the same output, and outputs are never cut
+// inside a range tombstone, so outputs don't overlap. Versions a range
+// tombstone hides from every reader are dropped, and so are tombstones, with
+// the versions they shadow, where the outputs are the oldest data for the key
+// (see Config.Below). Expired values count as tombstones. Merge operands no
+// snapshot tells apart are folded into the value below them. Config.Filter
+// then gets to drop or change what is left. The inputs are left in place;
+// the caller deletes them once the new table set is recorded.
+func Run(inputs []*sstable.Table, cfg Config) ([]*sstable.Table, Stats, error) {
+	var stats Stats
 	if len(inputs) == 0 {
-		return nil, nil
+		return nil, stats, nil
 	}
 
-	// We'll stream entries by scanning each file sequentially.
-	iters := make([]*tableIter, 0, len(inputs))
+	// We'll stream entries by scanning each table in key order.
+	iters := make([]iterator.Iterator, 0, len(inputs))
 	for _, t := range inputs {
-		it, err := newTableIter(t)
+		it, err := t.NewIterator()
 		if err != nil {
 			for _, it2 := range iters {
-				_ = it2.close()
+				_ = it2.Close()
 			}
-			return nil, err
+			return nil, stats, err
 		}
 		iters = append(iters, it)
 	}
-	defer func() {
-		for _, it := range iters {
-			_ = it.close()
-		}
-	}()
+	merged := iterator.NewMerging(iters...)
+	defer func() { _ = merged.Close() }()
 
-	// Initialize heap.
-	h := &mergeHeap{}
-	for _, it := range iters {
-		if it.next() {
-			heap.Push(h, it)
-		}
-		if it.err != nil {
-			return nil, it.err
+	var tombs []rangedel.Tombstone
+	for _, t := range inputs {
+		tombs = append(tombs, t.RangeDels().Tombstones()...)
+	}
+	rangeDels := rangedel.NewList(tombs)
+	// Tombstones still needed, sorted by start, go to the outputs along
+	// with the keys they start at or before.
+	var kept []rangedel.Tombstone
+	for _, t := range tombs {
+		if t.Seq > cfg.SmallestSnapshot || rangeOverlapsBelow(cfg.Below, t) {
+			kept = append(kept, t)
+		} else {
+			stats.RangeTombstonesDropped++
 		}
 	}
-
-	// Output file path.
-	finalName := sstable.FormatFilename(outputID)
-	tmpPath := filepath.Join(sstDir, fmt.Sprintf("%s.tmp", finalName))
-	outPath := filepath.Join(sstDir, finalName)
-
-	// We'll build output using the main SSTable builder to keep file format consistent
-	// (including Bloom filter, if enabled by the SSTable package).
-	mt := memtable.New()
-	var keys [][]byte
+	slices.SortFunc(kept, func(a, b rangedel.Tombstone) int { return bytes.Compare(a.Start, b.Start) })
 
 	var (
-		curKey []byte
-		best   memtable.Record
-		have   bool
+		outputs []*sstable.Table
+		w       *sstable.Writer // current output, nil between outputs
+		id      uint64
+		maxEnd  []byte // largest End of the tombstones in w
 	)
-	flushBest := func() error {
-		if !have {
+	// start opens the next output unless one is open.
+	start := func() error {
+		if w != nil {
 			return nil
 		}
-		mt.Apply(best)
-		keys = append(keys, cloneBytes(best.Key))
-		have = false
-		curKey = nil
-		return nil
+		id = cfg.NewFileNum()
+		var err error
+		w, err = sstable.NewWriter(tmpPath(cfg, id), sstable.WriterOptions{
+			Compression:     cfg.Compression,
+			PrefixExtractor: cfg.PrefixExtractor,
+		})
+		return err
 	}
-
-	for h.Len() > 0 {
-		it := heap.Pop(h).(*tableIter)
-		r := it.cur
-		if !have || !bytes.Equal(r.Key, curKey) {
-			if err := flushBest(); err != nil {
-				return nil, err
+	addTombstonesTo := func(key []byte) error {
+		for len(kept) > 0 && (key == nil || bytes.Compare(kept[0].Start, key) <= 0) {
+			if err := start(); err != nil {
+				return err
 			}
-			curKey = cloneBytes(r.Key)
-			best = r
-			have = true
-		} else {
-			if r.Seq > best.Seq {
-				best = r
+			w.AddRangeDel(kept[0])
+			if maxEnd == nil || bytes.Compare(kept[0].End, maxEnd) > 0 {
+				maxEnd = kept[0].End
 			}
+			kept = kept[1:]
 		}
-
-		if it.next() {
-			heap.Push(h, it)
+		return nil
+	}
+	// finish completes the current output and opens it as a table.
+	finish := func() error {
+		if w == nil {
+			return nil
 		}
-		if it.err != nil {
-			return nil, it.err
+		cur := w
+		w, maxEnd = nil, nil
+		if err := cur.Finish(); err != nil {
+			_ = os.Remove(tmpPath(cfg, id))
+			return err
 		}
+		outPath := filepath.Join(cfg.Dir, sstable.FormatFilename(id))
+		if err := os.Rename(tmpPath(cfg, id), outPath); err != nil {
+			return err
+		}
+		t, err := sstable.Open(outPath, id, cfg.Reader)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, t)
+		return nil
 	}
-	if err := flushBest(); err != nil {
-		return nil, err
-	}
-
-	// keys are produced in sorted order by the merge.
-	if err := sstable.Build(tmpPath, keys, mt, 16); err != nil {
-		return nil, err
-	}
-	if err := os.Rename(tmpPath, outPath); err != nil {
-		return nil, err
-	}
-
-	// Delete inputs.
-	for _, t := range inputs {
-		_ = os.Remove(t.Path)
-	}
-
-	return sstable.Open(outPath, outputID)
-}
-
-type tableIter struct {
-	t *sstable.Table
-	f *os.File
-	r *bufio.Reader
-
-	cur memtable.Record
-	err error
-
-	indexOffset uint64
-}
-
-func newTableIter(t *sstable.Table) (*tableIter, error) {
-	f, err := os.Open(t.Path)
-	if err != nil {
-		return nil, err
-	}
-	st, err := f.Stat()
-	if err != nil {
-		_ = f.Close()
-		return nil, err
-	}
-	// Footer size: 14 bytes.
-	if st.Size() < 14 {
-		_ = f.Close()
-		return nil, sstable.ErrCorrupt
-	}
-	footer := make([]byte, 14)
-	if _, err := f.ReadAt(footer, st.Size()-14); err != nil {
-		_ = f.Close()
-		return nil, err
+	add := func(r memtable.Record) error {
+		if err := start(); err != nil {
+			return err
+		}
+		return w.Add(r)
 	}
-	// Determine footer version by reading magic+version at the end.
-	gotMagic := binary.LittleEndian.Uint32(footer[8:12])
-	gotVer := binary.LittleEndian.Uint16(footer[12:14])
-	if gotMagic != 0x4c534d31 {
-		_ = f.Close()
-		return nil, sstable.ErrCorrupt
+	// addFiltered adds r after passing it through cfg.Filter if it is the
+	// newest version of its key and too new for any snapshot to read.
+	addFiltered := func(r memtable.Record, newest bool) error {
+		if cfg.Filter == nil || !newest || r.Tombstone || r.Merge || r.Expired(cfg.Now) || r.Seq <= cfg.NewestSnapshot {
+			return add(r)
+		}
+		switch d, v := cfg.Filter.Filter(cfg.Level, r.Key, r.Value, r.Seq); d {
+		case FilterRemove:
+			stats.FilterRemoved++
+			// Older versions a snapshot reads are kept, and must stay
+			// hidden from everyone else.
+			if r.Seq <= cfg.SmallestSnapshot && !keyInBelow(cfg.Below, r.Key) {
+				return nil
+			}
+			r = memtable.Record{Key: r.Key, Tombstone: true, Seq: r.Seq}
+		case FilterChangeValue:
+			stats.FilterChanged++
+			r.Value = v
+		default:
+			stats.FilterKept++
+		}
+		return add(r)
 	}
-	var idxOff uint64
-	if gotVer == 1 {
-		idxOff = binaryLittleU64(footer[0:8])
-	} else {
-		// v2 footer is 30 bytes: [idxOff][bloomOff][bloomLen][magic][ver]
-		if st.Size() < 30 {
-			_ = f.Close()
-			return nil, sstable.ErrCorrupt
+	fail := func(err error) ([]*sstable.Table, Stats, error) {
+		if w != nil {
+			w.Abort()
 		}
-		v2 := make([]byte, 30)
-		if _, err := f.ReadAt(v2, st.Size()-30); err != nil {
-			_ = f.Close()
-			return nil, err
+		for _, t := range outputs {
+			_ = os.Remove(t.Path)
 		}
-		idxOff = binary.LittleEndian.Uint64(v2[0:8])
+		return nil, Stats{}, err
 	}
 
-	if _, err := f.Seek(0, 0); err != nil {
-		_ = f.Close()
-		return nil, err
+	// The merge yields the versions of each key newest first. Once a
+	// version at or below SmallestSnapshot has been kept, every older one
+	// is invisible to all readers and can be dropped.
+	var (
+		lastKey    []byte
+		lastKeySeq uint64 // Seq of the previous version of lastKey
+		// The merge operands of lastKey from the first one at or below
+		// SmallestSnapshot down, newest first, while being folded.
+		operands []memtable.Record
+		// Whether operands start at the newest version of lastKey.
+		foldNewest bool
+	)
+	// endFold writes the folded operands. base is the version below them, or
+	// nil; dead means the key has no value there.
+	endFold := func(base *memtable.Record, dead bool) error {
+		ops := operands
+		operands = nil
+		values := make([][]byte, len(ops))
+		for j, op := range ops {
+			values[len(ops)-1-j] = op.Value
+		}
+		var existing []byte
+		full := dead
+		if !dead && base != nil && base.ExpiresAt == 0 {
+			// A value that expires would leave the operands applying to
+			// nothing, so it is only folded into without a TTL.
+			existing, full = base.Value, true
+		}
+		if mo := cfg.MergeOperator; mo != nil {
+			if full {
+				if v, err := mo.Merge(ops[0].Key, existing, values); err == nil {
+					stats.MergeOperandsFolded += len(ops)
+					return addFiltered(memtable.Record{Key: ops[0].Key, Value: v, Seq: ops[0].Seq}, foldNewest)
+				}
+				// Left to fail again, visibly, when the key is read.
+			} else if len(ops) > 1 {
+				if v, ok := mo.PartialMerge(ops[0].Key, values); ok {
+					stats.MergeOperandsFolded += len(ops) - 1
+					ops = []memtable.Record{{Key: ops[0].Key, Value: v, Seq: ops[0].Seq, Merge: true}}
+				}
+			}
+		}
+		for _, op := range ops {
+			if err := add(op); err != nil {
+				return err
+			}
+		}
+		if base != nil {
+			return add(*base)
+		}
+		return nil
 	}
-	return &tableIter{
-		t:           t,
-		f:           f,
-		r:           bufio.NewReaderSize(f, 64*1024),
-		indexOffset: idxOff,
-	}, nil
-}
-
-func (it *tableIter) next() bool {
-	if it.err != nil {
-		return false
+	for merged.First(); merged.Valid(); merged.Next() {
+		r := merged.Record()
+		if operands != nil {
+			if bytes.Equal(r.Key, lastKey) {
+				// r is below the operands: fold them into it, or go on
+				// collecting if it is one more of them.
+				hidden := r.Seq < rangeDels.MaxSeq(r.Key, cfg.SmallestSnapshot)
+				if r.Merge && !hidden {
+					operands = append(operands, cloneRecord(r))
+					continue
+				}
+				var base *memtable.Record
+				if !hidden {
+					base = &r
+				}
+				if err := endFold(base, hidden || r.Tombstone || r.Expired(cfg.Now)); err != nil {
+					return fail(err)
+				}
+				// Every older version is dropped below.
+				lastKeySeq = r.Seq
+				continue
+			}
+			if err := endFold(nil, !keyInBelow(cfg.Below, lastKey)); err != nil {
+				return fail(err)
+			}
+		}
+		if lastKey == nil || !bytes.Equal(r.Key, lastKey) {
+			// Only cut outputs between keys, and only where the range
+			// tombstones written so far end before the next output starts:
+			// a table's range takes in its tombstones.
+			if w != nil && cfg.TargetFileSize > 0 && w.EstimatedSize() >= cfg.TargetFileSize {
+				next := r.Key
+				if len(kept) > 0 && bytes.Compare(kept[0].Start, next) < 0 {
+					next = kept[0].Start
+				}
+				if maxEnd == nil || bytes.Compare(maxEnd, next) < 0 {
+					if err := finish(); err != nil {
+						return fail(err)
+					}
+				}
+			}
+			if err := addTombstonesTo(r.Key); err != nil {
+				return fail(err)
+			}
+			lastKey = append(lastKey[:0], r.Key...)
+			lastKeySeq = ^uint64(0)
+		}
+		// Readers treat an expired value as absent, whatever their
+		// snapshot, so it is a tombstone from here on.
+		expired := r.Expired(cfg.Now)
+		newest := lastKeySeq == ^uint64(0)
+		drop := lastKeySeq <= cfg.SmallestSnapshot ||
+			r.Seq < rangeDels.MaxSeq(r.Key, cfg.SmallestSnapshot) ||
+			// Every reader sees the tombstone, and the versions it hides
+			// are all in this merge, which drops them right after.
+			((r.Tombstone || expired) && r.Seq <= cfg.SmallestSnapshot && !keyInBelow(cfg.Below, r.Key))
+		lastKeySeq = r.Seq
+		if drop {
+			if r.Tombstone {
+				stats.TombstonesDropped++
+			} else if expired {
+				stats.ExpiredDropped++
+			}
+			continue
+		}
+		if r.Merge && r.Seq <= cfg.SmallestSnapshot {
+			// The versions below would be dropped as hidden by this one;
+			// collect them instead.
+			operands = []memtable.Record{cloneRecord(r)}
+			foldNewest = newest
+			continue
+		}
+		if err := addFiltered(r, newest); err != nil {
+			return fail(err)
+		}
 	}
-	off, _ := it.f.Seek(0, 1)
-	if uint64(off) >= it.indexOffset {
-		return false
+	if err := merged.Err(); err != nil {
+		return fail(err)
 	}
-	rec, ok, err := readEntry(it.r)
-	if err != nil {
-		it.err = err
-		return false
+	if operands != nil {
+		if err := endFold(nil, !keyInBelow(cfg.Below, lastKey)); err != nil {
+			return fail(err)
+		}
 	}
-	if !ok {
-		return false
+	if err := addTombstonesTo(nil); err != nil {
+		return fail(err)
 	}
-	it.cur = rec
-	return true
-}
-
-func (it *tableIter) close() error {
-	if it.f != nil {
-		return it.f.Close()
+	if err := finish(); err != nil {
+		return fail(err)
 	}
-	return nil
+	return outputs, stats, nil
 }
 
-type mergeHeap []*tableIter
-
-func (h mergeHeap) Len() int           { return len(h) }
-func (h mergeHeap) Less(i, j int) bool { return bytes.Compare(h[i].cur.Key, h[j].cur.Key) < 0 }
-func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
-func (h *mergeHeap) Push(x any)        { *h = append(*h, x.(*tableIter)) }
-func (h *mergeHeap) Pop() any {
-	old := *h
-	n := len(old)
-	x := old[n-1]
-	*h = old[:n-1]
-	return x
+// tmpPath is where output id is written before it is renamed into place.
+func tmpPath(cfg Config, id uint64) string {
+	return filepath.Join(cfg.Dir, sstable.FormatFilename(id)+".tmp")
 }
 
-func cloneBytes(b []byte) []byte {
-	out := make([]byte, len(b))
-	copy(out, b)
-	return out
+func cloneRecord(r memtable.Record) memtable.Record {
+	r.Key = bytes.Clone(r.Key)
+	r.Value = bytes.Clone(r.Value)
+	return r
 }
 
-func binaryLittleU64(b []byte) uint64 {
-	_ = b[7]
-	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
-		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+// keyInBelow reports whether some table of levels may hold key.
+func keyInBelow(levels [][]*sstable.Table, key []byte) bool {
+	for _, tables := range levels {
+		i := sort.Search(len(tables), func(i int) bool {
+			return bytes.Compare(tables[i].Largest(), key) >= 0
+		})
+		if i < len(tables) && bytes.Compare(tables[i].Smallest(), key) <= 0 {
+			return true
+		}
+	}
+	return false
 }
 
-func readEntry(r *bufio.Reader) (memtable.Record, bool, error) {
-	var klenBuf [4]byte
-	if _, err := io.ReadFull(r, klenBuf[:]); err != nil {
-		if errors.Is(err, io.EOF) {
-			return memtable.Record{}, false, nil
-		}
-		if errors.Is(err, io.ErrUnexpectedEOF) {
-			return memtable.Record{}, false, sstable.ErrCorrupt
+// rangeOverlapsBelow reports whether some table of levels overlaps t.
+func rangeOverlapsBelow(levels [][]*sstable.Table, t rangedel.Tombstone) bool {
+	for _, tables := range levels {
+		for _, tbl := range tables {
+			if t.Overlaps(tbl.Smallest(), tbl.Largest()) {
+				return true
+			}
 		}
-		return memtable.Record{}, false, err
-	}
-	klen := binary.LittleEndian.Uint32(klenBuf[:])
-	if klen == 0 {
-		return memtable.Record{}, false, sstable.ErrCorrupt
-	}
-	k := make([]byte, klen)
-	if _, err := io.ReadFull(r, k); err != nil {
-		return memtable.Record{}, false, sstable.ErrCorrupt
-	}
-	tomb, err := r.ReadByte()
-	if err != nil {
-		return memtable.Record{}, false, sstable.ErrCorrupt
-	}
-	var vlenBuf [4]byte
-	if _, err := io.ReadFull(r, vlenBuf[:]); err != nil {
-		return memtable.Record{}, false, sstable.ErrCorrupt
-	}
-	vlen := binary.LittleEndian.Uint32(vlenBuf[:])
-	v := make([]byte, vlen)
-	if _, err := io.ReadFull(r, v); err != nil {
-		return memtable.Record{}, false, sstable.ErrCorrupt
-	}
-	var seqBuf [8]byte
-	if _, err := io.ReadFull(r, seqBuf[:]); err != nil {
-		return memtable.Record{}, false, sstable.ErrCorrupt
 	}
-	seq := binary.LittleEndian.Uint64(seqBuf[:])
-	return memtable.Record{Key: k, Value: v, Tombstone: tomb == 1, Seq: seq}, true, nil
+	return false
 }
@@ -0,0 +1,279 @@
+package compaction
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/merge"
+	"github.com/ChinmayNoob/lsm-go/rangedel"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+)
+
+func put(key, value string, seq uint64) memtable.Record {
+	return memtable.Record{Key: []byte(key), Value: []byte(value), Seq: seq}
+}
+
+func del(key string, seq uint64) memtable.Record {
+	return memtable.Record{Key: []byte(key), Tombstone: true, Seq: seq}
+}
+
+func mrg(key, operand string, seq uint64) memtable.Record {
+	return memtable.Record{Key: []byte(key), Value: []byte(operand), Merge: true, Seq: seq}
+}
+
+func rdel(start, end string, seq uint64) rangedel.Tombstone {
+	return rangedel.Tombstone{Start: []byte(start), End: []byte(end), Seq: seq}
+}
+
+// testTables hands out table numbers for one test's directory.
+type testTables struct {
+	t   *testing.T
+	dir string
+	num uint64
+}
+
+func newTestTables(t *testing.T) *testTables {
+	return &testTables{t: t, dir: t.TempDir()}
+}
+
+func (tt *testTables) newFileNum() uint64 {
+	tt.num++
+	return tt.num
+}
+
+// table writes recs, which must be in order, and tombs as a table.
+func (tt *testTables) table(recs []memtable.Record, tombs ...rangedel.Tombstone) *sstable.Table {
+	tt.t.Helper()
+	num := tt.newFileNum()
+	path := filepath.Join(tt.dir, sstable.FormatFilename(num))
+	w, err := sstable.NewWriter(path, sstable.WriterOptions{})
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	for _, r := range recs {
+		if err := w.Add(r); err != nil {
+			tt.t.Fatal(err)
+		}
+	}
+	for _, rd := range tombs {
+		w.AddRangeDel(rd)
+	}
+	if err := w.Finish(); err != nil {
+		tt.t.Fatal(err)
+	}
+	tbl, err := sstable.Open(path, num, sstable.ReaderOptions{})
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	return tbl
+}
+
+// dump lists what tables hold, one string per record or range tombstone.
+func dump(t *testing.T, tables []*sstable.Table) []string {
+	t.Helper()
+	var out []string
+	for _, tbl := range tables {
+		it, err := tbl.NewIterator()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for it.First(); it.Valid(); it.Next() {
+			r := it.Record()
+			switch {
+			case r.Tombstone:
+				out = append(out, fmt.Sprintf("%s@%d del", r.Key, r.Seq))
+			case r.Merge:
+				out = append(out, fmt.Sprintf("%s@%d merge=%s", r.Key, r.Seq, r.Value))
+			default:
+				out = append(out, fmt.Sprintf("%s@%d=%s", r.Key, r.Seq, r.Value))
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		_ = it.Close()
+		for _, rd := range tbl.RangeDels().Tombstones() {
+			out = append(out, fmt.Sprintf("[%s,%s)@%d", rd.Start, rd.End, rd.Seq))
+		}
+	}
+	return out
+}
+
+func TestRunDropRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		recs     []memtable.Record
+		tombs    []rangedel.Tombstone
+		snapshot uint64 // Config.SmallestSnapshot
+		below    bool   // an older table under the output holds every key
+		want     []string
+		stats    Stats
+	}{
+		{
+			name:     "older versions nobody reads",
+			recs:     []memtable.Record{put("k", "c", 5), put("k", "b", 3), put("k", "a", 1)},
+			snapshot: 10,
+			want:     []string{"k@5=c"},
+		},
+		{
+			name:     "version a snapshot reads",
+			recs:     []memtable.Record{put("k", "c", 5), put("k", "b", 3), put("k", "a", 1)},
+			snapshot: 4,
+			want:     []string{"k@5=c", "k@3=b"},
+		},
+		{
+			name:     "snapshot at a version",
+			recs:     []memtable.Record{put("k", "c", 5), put("k", "b", 3), put("k", "a", 1)},
+			snapshot: 3,
+			want:     []string{"k@5=c", "k@3=b"},
+		},
+		{
+			name:     "tombstone over the oldest data",
+			recs:     []memtable.Record{del("k", 5), put("k", "b", 3)},
+			snapshot: 10,
+			want:     nil,
+			stats:    Stats{TombstonesDropped: 1},
+		},
+		{
+			name:     "tombstone over deeper data",
+			recs:     []memtable.Record{del("k", 5), put("k", "b", 3)},
+			snapshot: 10,
+			below:    true,
+			want:     []string{"k@5 del"},
+		},
+		{
+			name:     "tombstone newer than a snapshot",
+			recs:     []memtable.Record{del("k", 5), put("k", "b", 3)},
+			snapshot: 4,
+			want:     []string{"k@5 del", "k@3=b"},
+		},
+		{
+			name:     "range tombstone hides older versions",
+			recs:     []memtable.Record{put("k", "b", 3), put("z", "y", 3)},
+			tombs:    []rangedel.Tombstone{rdel("a", "m", 5)},
+			snapshot: 10,
+			want:     []string{"z@3=y"},
+			stats:    Stats{RangeTombstonesDropped: 1},
+		},
+		{
+			name:     "range tombstone newer than a snapshot",
+			recs:     []memtable.Record{put("k", "b", 3), put("z", "y", 3)},
+			tombs:    []rangedel.Tombstone{rdel("a", "m", 5)},
+			snapshot: 4,
+			want:     []string{"k@3=b", "z@3=y", "[a,m)@5"},
+		},
+		{
+			name:     "range tombstone over deeper data",
+			recs:     []memtable.Record{put("k", "b", 3)},
+			tombs:    []rangedel.Tombstone{rdel("a", "m", 5)},
+			snapshot: 10,
+			below:    true,
+			want:     []string{"[a,m)@5"},
+		},
+		{
+			name:     "merge operands folded into the value",
+			recs:     []memtable.Record{mrg("k", "c", 3), mrg("k", "b", 2), put("k", "a", 1)},
+			snapshot: 10,
+			want:     []string{"k@3=a,b,c"},
+			stats:    Stats{MergeOperandsFolded: 2},
+		},
+		{
+			name:     "merge operand newer than a snapshot",
+			recs:     []memtable.Record{mrg("k", "c", 3), mrg("k", "b", 2), put("k", "a", 1)},
+			snapshot: 2,
+			want:     []string{"k@3 merge=c", "k@2=a,b"},
+			stats:    Stats{MergeOperandsFolded: 1},
+		},
+		{
+			name:     "merge operands over nothing",
+			recs:     []memtable.Record{mrg("k", "c", 3), mrg("k", "b", 2)},
+			snapshot: 10,
+			want:     []string{"k@3=b,c"},
+			stats:    Stats{MergeOperandsFolded: 2},
+		},
+		{
+			name:     "merge operands over deeper data",
+			recs:     []memtable.Record{mrg("k", "c", 3), mrg("k", "b", 2)},
+			snapshot: 10,
+			below:    true,
+			want:     []string{"k@3 merge=b,c"},
+			stats:    Stats{MergeOperandsFolded: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := newTestTables(t)
+			cfg := Config{
+				Dir:              tables.dir,
+				SmallestSnapshot: tt.snapshot,
+				NewestSnapshot:   tt.snapshot,
+				MergeOperator:    merge.Append([]byte(",")),
+				Level:            1,
+				NewFileNum:       tables.newFileNum,
+			}
+			if tt.below {
+				cfg.Below = [][]*sstable.Table{{tables.table([]memtable.Record{put("a", "old", 0), put("z", "old", 0)})}}
+			}
+			// Split the versions across two inputs so the merge interleaves
+			// them.
+			var newer, older []memtable.Record
+			for i, r := range tt.recs {
+				if i%2 == 0 {
+					newer = append(newer, r)
+				} else {
+					older = append(older, r)
+				}
+			}
+			inputs := []*sstable.Table{tables.table(newer, tt.tombs...), tables.table(older)}
+			outputs, stats, err := Run(inputs, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dump(t, outputs); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("outputs = %q, want %q", got, tt.want)
+			}
+			if stats != tt.stats {
+				t.Errorf("stats = %+v, want %+v", stats, tt.stats)
+			}
+		})
+	}
+}
+
+// Outputs are cut between keys once they reach TargetFileSize, but never
+// inside a range tombstone, so they don't overlap.
+func TestRunCutsAroundRangeTombstones(t *testing.T) {
+	tables := newTestTables(t)
+	recs := []memtable.Record{
+		put("a", "1", 1), put("b", "1", 1), put("c", "1", 1), put("d", "1", 1), put("f", "1", 1), put("g", "1", 1),
+	}
+	in := tables.table(recs, rdel("b", "e", 10))
+	outputs, _, err := Run([]*sstable.Table{in}, Config{
+		Dir:              tables.dir,
+		SmallestSnapshot: 5, // keeps the tombstone, and the keys under it
+		TargetFileSize:   1, // one key per output, where allowed
+		NewFileNum:       tables.newFileNum,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for i, out := range outputs {
+		got = append(got, dump(t, []*sstable.Table{out}))
+		if i > 0 && bytes.Compare(outputs[i-1].Largest(), out.Smallest()) >= 0 {
+			t.Errorf("output %d [%s, %s] overlaps the one before, which ends at %s",
+				i, out.Smallest(), out.Largest(), outputs[i-1].Largest())
+		}
+	}
+	want := [][]string{
+		{"a@1=1"},
+		{"b@1=1", "c@1=1", "d@1=1", "[b,e)@10"},
+		{"f@1=1"},
+		{"g@1=1"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("outputs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,39 @@
+package compaction
+
+// FilterDecision is what a Filter does with a record.
+type FilterDecision int
+
+const (
+	// FilterKeep writes the record unchanged.
+	FilterKeep FilterDecision = iota
+	// FilterRemove deletes the key: the record is dropped, or turned into a
+	// tombstone if an older version may be kept for a snapshot or sit in a
+	// deeper level.
+	FilterRemove
+	// FilterChangeValue writes the record with the value Filter returned.
+	FilterChangeValue
+)
+
+// Filter lets compaction drop or rewrite values by rules of its own, like
+// purging the keys of a deleted tenant.
+//
+// It does not see every record a compaction writes. Run only calls it for
+// the newest value of each key, and only if that value is newer than
+// Config.NewestSnapshot, so no snapshot can tell the decision apart. It is
+// not called for:
+//   - older versions of a key, which snapshots may still read;
+//   - a newest value a live snapshot can read, until a compaction after the
+//     snapshot is released rewrites it;
+//   - tombstones, merge operands and expired values;
+//   - tables a compaction moves to the next level without rewriting them.
+//
+// A removed or changed value can therefore stay on disk, visible to older
+// snapshots, until those are released and the key is compacted again.
+type Filter interface {
+	// Name identifies the filter in verbose output.
+	Name() string
+	// Filter decides on the value of key at seq, being written to level.
+	// It runs on the compaction worker with no DB lock held and must not
+	// write to the DB. The value must not be modified or kept.
+	Filter(level int, key, value []byte, seq uint64) (FilterDecision, []byte)
+}
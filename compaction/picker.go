@@ -0,0 +1,162 @@
+package compaction
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/ChinmayNoob/lsm-go/sstable"
+)
+
+// NumLevels is the depth of the LSM tree. L0 holds memtable flushes, whose
+// key ranges may overlap; in every deeper level the tables are sorted by key
+// and don't overlap, so a lookup probes at most one table per level.
+const NumLevels = 7
+
+// Levels lists the live tables per level: L0 in ascending ID (flush) order,
+// deeper levels in ascending key order.
+type Levels [NumLevels][]*sstable.Table
+
+// LevelOptions controls when a level is compacted into the next one.
+type LevelOptions struct {
+	// L0Trigger is the number of L0 tables above which L0 is compacted.
+	// Zero disables compaction altogether.
+	L0Trigger int
+	// BaseLevelBytes is the size target of L1.
+	BaseLevelBytes int64
+	// Multiplier is the size ratio between consecutive levels from L1 on.
+	Multiplier int
+}
+
+// MaxBytes returns the size target of level (>= 1).
+func (o LevelOptions) MaxBytes(level int) int64 {
+	n := o.BaseLevelBytes
+	for l := 1; l < level; l++ {
+		n *= int64(o.Multiplier)
+	}
+	return n
+}
+
+// Compaction is one unit of work: Inputs[0] from Level and the tables of
+// Level+1 that overlap them in Inputs[1].
+type Compaction struct {
+	Level  int
+	Inputs [2][]*sstable.Table
+}
+
+// OutputLevel is the level the merged tables go to.
+func (c *Compaction) OutputLevel() int { return c.Level + 1 }
+
+// IsTrivialMove reports whether the single input can move down a level as
+// is, without rewriting it.
+func (c *Compaction) IsTrivialMove() bool {
+	return len(c.Inputs[0]) == 1 && len(c.Inputs[1]) == 0
+}
+
+// Pick returns the most urgent compaction, or nil if every level is within
+// its target. L0 is scored by table count, deeper levels by size against
+// MaxBytes. Within a level tables are picked round-robin: pointers[level]
+// holds the largest key of the last table compacted there and is advanced.
+func Pick(v *Levels, o LevelOptions, pointers *[NumLevels][]byte) *Compaction {
+	if o.L0Trigger <= 0 {
+		return nil
+	}
+	best, bestScore := -1, 1.0
+	if len(v[0]) > o.L0Trigger {
+		best, bestScore = 0, float64(len(v[0]))/float64(o.L0Trigger)
+	}
+	for level := 1; level < NumLevels-1; level++ {
+		score := float64(TotalSize(v[level])) / float64(o.MaxBytes(level))
+		if score > bestScore {
+			best, bestScore = level, score
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	c := &Compaction{Level: best}
+	if best == 0 {
+		// Flushes overlap each other, so take them all: any older L0 table
+		// left behind could hold versions older than those moved down.
+		c.Inputs[0] = append([]*sstable.Table(nil), v[0]...)
+	} else {
+		tables := v[best]
+		i := 0
+		if p := pointers[best]; p != nil {
+			for i < len(tables) && bytes.Compare(tables[i].Largest(), p) <= 0 {
+				i++
+			}
+			if i == len(tables) {
+				i = 0
+			}
+		}
+		c.Inputs[0] = []*sstable.Table{tables[i]}
+	}
+	smallest, largest := KeyRange(c.Inputs[0])
+	c.Inputs[1] = Overlapping(v[best+1], smallest, largest)
+	pointers[best] = largest
+	return c
+}
+
+// TotalSize sums the file sizes of tables.
+func TotalSize(tables []*sstable.Table) int64 {
+	var n int64
+	for _, t := range tables {
+		n += t.Size()
+	}
+	return n
+}
+
+// KeyRange returns the smallest and largest key across tables.
+func KeyRange(tables []*sstable.Table) (smallest, largest []byte) {
+	for _, t := range tables {
+		if smallest == nil || bytes.Compare(t.Smallest(), smallest) < 0 {
+			smallest = t.Smallest()
+		}
+		if largest == nil || bytes.Compare(t.Largest(), largest) > 0 {
+			largest = t.Largest()
+		}
+	}
+	return smallest, largest
+}
+
+// Overlapping returns the tables whose key range intersects [smallest, largest].
+func Overlapping(tables []*sstable.Table, smallest, largest []byte) []*sstable.Table {
+	var out []*sstable.Table
+	for _, t := range tables {
+		if bytes.Compare(t.Largest(), smallest) < 0 || bytes.Compare(t.Smallest(), largest) > 0 {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// Apply returns v with c's inputs replaced by outputs in the output level.
+func (c *Compaction) Apply(v *Levels, outputs []*sstable.Table) Levels {
+	drop := make(map[uint64]bool)
+	for _, in := range c.Inputs {
+		for _, t := range in {
+			drop[t.ID] = true
+		}
+	}
+	var nv Levels
+	for level, tables := range v {
+		for _, t := range tables {
+			if !drop[t.ID] {
+				nv[level] = append(nv[level], t)
+			}
+		}
+	}
+	out := c.OutputLevel()
+	nv[out] = append(nv[out], outputs...)
+	SortLevel(nv[out])
+	return nv
+}
+
+// SortLevel orders the tables of a level >= 1 by key.
+func SortLevel(tables []*sstable.Table) {
+	slices.SortFunc(tables, func(a, b *sstable.Table) int {
+		return bytes.Compare(a.Smallest(), b.Smallest())
+	})
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ChinmayNoob/lsm-go/compaction"
+	"github.com/ChinmayNoob/lsm-go/manifest"
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+	"github.com/ChinmayNoob/lsm-go/wal"
+)
+
+// A full memtable is frozen into the version's imm queue and a fresh one takes the writes. Two
+// goroutines do the slow work off the write path: the flush worker turns
+// frozen memtables into L0 tables, oldest first, and the compaction worker
+// keeps the levels within their targets. Both drop d.mu while writing tables
+// and retake it to commit the result, and both signal d.bgCond whenever the
+// state they wait on changes.
+
+// immMemtable is a frozen memtable waiting to be flushed.
+type immMemtable struct {
+	mem *memtable.Memtable
+	log uint64 // oldest WAL holding its writes
+}
+
+// startBackgroundLocked launches the flush and compaction workers.
+func (d *DB) startBackgroundLocked() {
+	d.bgWG.Add(2)
+	go d.flushWorker()
+	go d.compactionWorker()
+}
+
+// maybeRotateLocked freezes the memtable once it is full, waiting for the
+// flush worker if too many frozen memtables are already queued.
+func (d *DB) maybeRotateLocked() error {
+	for d.opts.MemtableMaxBytes > 0 && d.memBytes >= d.opts.MemtableMaxBytes {
+		if d.bgErr != nil {
+			return d.bgErr
+		}
+		if d.closed {
+			// The write is in the WAL; the next Open replays it.
+			return nil
+		}
+		if len(d.current.imm) < d.opts.MaxImmutableMemtables {
+			return d.rotateLocked()
+		}
+		d.bgCond.Wait()
+	}
+	return nil
+}
+
+// rotateLocked starts a new WAL and memtable and queues the old memtable for
+// flushing. The old log is kept until the table holding its contents is
+// committed.
+func (d *DB) rotateLocked() error {
+	newLog := d.newFileNumLocked()
+	newW, err := wal.Open(d.logPath(newLog), d.opts.SyncOnWrite)
+	if err != nil {
+		return err
+	}
+	if err := d.w.Close(); err != nil {
+		_ = newW.Close()
+		return err
+	}
+	v := d.current.clone()
+	v.imm = append(v.imm, &immMemtable{mem: v.mem, log: d.memLog})
+	v.mem = memtable.New()
+	d.installLocked(v)
+	d.memBytes = 0
+	d.w = newW
+	d.logNum = newLog
+	d.memLog = newLog
+	d.bgCond.Broadcast()
+	return nil
+}
+
+// flushWorker flushes frozen memtables until the DB is closed and none are
+// left, or a background error stops it.
+func (d *DB) flushWorker() {
+	defer d.bgWG.Done()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		for len(d.current.imm) == 0 && !d.closed && d.bgErr == nil {
+			d.bgCond.Wait()
+		}
+		if len(d.current.imm) == 0 || d.bgErr != nil {
+			return
+		}
+		if err := d.flushLocked(); err != nil {
+			d.setBackgroundErrorLocked(err)
+			return
+		}
+		d.bgCond.Broadcast()
+	}
+}
+
+// compactionWorker runs compactions while some level is over its target,
+// pausing while files are ingested. Once the DB is closed it finishes the work left by the last flushes, then
+// exits.
+func (d *DB) compactionWorker() {
+	defer d.bgWG.Done()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.bgErr == nil {
+		// No compaction starts while an ingestion waits for them to stop.
+		if d.ingests == 0 {
+			if c := compaction.Pick(&d.current.levels, d.levelOptions(), &d.pointers); c != nil {
+				if err := d.compactLocked(c); err != nil {
+					d.setBackgroundErrorLocked(err)
+					return
+				}
+				d.bgCond.Broadcast()
+				continue
+			}
+		}
+		if d.closed && len(d.current.imm) == 0 {
+			return
+		}
+		d.bgCond.Wait()
+	}
+}
+
+// setBackgroundErrorLocked records the first background failure, or a WAL
+// failure on the write path. Writes fail with it from then on, since their
+// data could no longer be logged or flushed.
+func (d *DB) setBackgroundErrorLocked(err error) {
+	if d.bgErr == nil {
+		d.bgErr = err
+	}
+	if d.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "[background] %v\n", err)
+	}
+	d.bgCond.Broadcast()
+}
+
+// flushLocked writes the oldest frozen memtable to an L0 table and commits
+// it. d.mu is released while the table is written.
+func (d *DB) flushLocked() error {
+	imm := d.current.imm[0]
+	id := d.newFileNumLocked()
+	d.pending[id] = true
+	defer delete(d.pending, id)
+
+	sstPath := filepath.Join(d.sstDir, sstable.FormatFilename(id))
+	if d.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "[flush] flushing memtable to SSTable-%06d\n", id)
+	}
+	wo, ro := d.writerOptions(), d.readerOptions()
+	d.mu.Unlock()
+	tbl, err := func() (*sstable.Table, error) {
+		if err := writeMemtable(sstPath, imm.mem, wo); err != nil {
+			return nil, err
+		}
+		return sstable.Open(sstPath, id, ro)
+	}()
+	d.mu.Lock()
+	if err != nil {
+		return err
+	}
+
+	// Writes older than the next unflushed memtable are all in tables now.
+	logNumber := d.memLog
+	if len(d.current.imm) > 1 {
+		logNumber = d.current.imm[1].log
+	}
+	if err := d.logAndApplyLocked(&manifest.VersionEdit{
+		LogNumber: &logNumber,
+		Added:     []manifest.FileMeta{{Level: 0, Num: id}},
+	}); err != nil {
+		return err
+	}
+	v := d.current.clone()
+	v.levels[0] = append(v.levels[0], tbl)
+	v.imm = v.imm[1:]
+	d.installLocked(v)
+	if d.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "[flush] SSTable-%06d created (with Bloom filter)\n", id)
+	}
+	return d.removeObsoleteFilesLocked()
+}
+
+// writeMemtable writes every version and range tombstone mem holds to a new
+// table at path.
+func writeMemtable(path string, mem *memtable.Memtable, o sstable.WriterOptions) error {
+	w, err := sstable.NewWriter(path, o)
+	if err != nil {
+		return err
+	}
+	it := mem.NewIterator()
+	for it.First(); it.Valid(); it.Next() {
+		if err := w.Add(it.Record()); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	for _, t := range mem.RangeDels().Tombstones() {
+		w.AddRangeDel(t)
+	}
+	return w.Finish()
+}
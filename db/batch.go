@@ -0,0 +1,73 @@
+package db
+
+import (
+	"time"
+
+	"github.com/ChinmayNoob/lsm-go/wal"
+)
+
+// WriteBatch collects puts, merges and deletes that DB.Write applies atomically:
+// they share one WAL record and a contiguous range of sequence numbers, so
+// after a crash either all of them are replayed or none is.
+//
+// Operations are applied in the order they were added, so a later op on the
+// same key wins. The zero value is an empty batch.
+type WriteBatch struct {
+	ops []wal.Record
+}
+
+func (b *WriteBatch) Put(key, value []byte) {
+	if value == nil {
+		// Treat nil as empty, same as DB.Put always has.
+		value = []byte{}
+	}
+	b.ops = append(b.ops, wal.Record{
+		Op:    wal.OpPut,
+		Key:   cloneBytes(key),
+		Value: cloneBytes(value),
+	})
+}
+
+// PutWithTTL puts a value that expires ttl from now (see DB.PutWithTTL).
+func (b *WriteBatch) PutWithTTL(key, value []byte, ttl time.Duration) {
+	b.Put(key, value)
+	b.ops[len(b.ops)-1].ExpiresAt = time.Now().Add(ttl).UnixNano()
+}
+
+func (b *WriteBatch) Delete(key []byte) {
+	b.ops = append(b.ops, wal.Record{
+		Op:  wal.OpDelete,
+		Key: cloneBytes(key),
+	})
+}
+
+// Merge adds operand to key's value (see DB.Merge).
+func (b *WriteBatch) Merge(key, operand []byte) {
+	if operand == nil {
+		operand = []byte{}
+	}
+	b.ops = append(b.ops, wal.Record{
+		Op:    wal.OpMerge,
+		Key:   cloneBytes(key),
+		Value: cloneBytes(operand),
+	})
+}
+
+// DeleteRange deletes the keys in [start, end).
+func (b *WriteBatch) DeleteRange(start, end []byte) {
+	b.ops = append(b.ops, wal.Record{
+		Op:    wal.OpRangeDelete,
+		Key:   cloneBytes(start),
+		Value: cloneBytes(end),
+	})
+}
+
+// Clear empties the batch so it can be reused.
+func (b *WriteBatch) Clear() {
+	b.ops = b.ops[:0]
+}
+
+// Count returns the number of operations in the batch.
+func (b *WriteBatch) Count() int {
+	return len(b.ops)
+}
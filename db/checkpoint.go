@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ChinmayNoob/lsm-go/manifest"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+	"github.com/ChinmayNoob/lsm-go/wal"
+)
+
+var ErrCheckpointExists = errors.New("checkpoint directory already exists")
+
+// Checkpoint writes a consistent copy of the DB to dir, which must not exist
+// yet, while reads and writes go on. The copy holds every write committed
+// before Checkpoint was called and can be opened with Open as a separate DB.
+//
+// Tables are immutable, so they are hard-linked (copied across file
+// systems); the WALs still holding unflushed writes are copied up to their
+// size at the time of the call, and a fresh manifest lists the tables.
+func (d *DB) Checkpoint(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%w: %s", ErrCheckpointExists, dir)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+
+	// At the head of the write queue no write is half applied, so the
+	// manifest state and the logs' sizes describe the same point in time.
+	d.leadWriteQueueLocked()
+	st := d.man.State()
+	logs, err := d.listLogs(st.LogNumber)
+	if err != nil {
+		d.popWritersLocked(1)
+		return err
+	}
+	sizes := make([]int64, len(logs))
+	for i, num := range logs {
+		fi, err := os.Stat(d.logPath(num))
+		if err != nil {
+			d.popWritersLocked(1)
+			return err
+		}
+		sizes[i] = fi.Size()
+	}
+	v := d.refLocked()
+	manNum := d.newFileNumLocked()
+	st.NextFileNum = d.nextFile
+	st.LastSeq = d.seq - 1
+	d.popWritersLocked(1)
+
+	// The version keeps the tables, and logRefs the logs, from file GC.
+	for _, num := range logs {
+		d.logRefs[num]++
+	}
+	defer func() {
+		for _, num := range logs {
+			if d.logRefs[num]--; d.logRefs[num] == 0 {
+				delete(d.logRefs, num)
+			}
+		}
+		d.unrefLocked(v)
+	}()
+
+	d.mu.Unlock()
+	err = d.writeCheckpoint(dir, v, manNum, st, logs, sizes)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+	}
+	d.mu.Lock()
+	if err == nil && d.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "[checkpoint] %s: %d SSTables, %d logs\n", dir, v.numTables(), len(logs))
+	}
+	return err
+}
+
+// writeCheckpoint fills dir with v's tables, the first sizes[i] bytes of
+// each log and manifest manNum holding st.
+func (d *DB) writeCheckpoint(dir string, v *version, manNum uint64, st manifest.State, logs []uint64, sizes []int64) error {
+	sstDir := filepath.Join(dir, "sstables")
+	if err := os.MkdirAll(sstDir, 0o755); err != nil {
+		return err
+	}
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			if err := linkOrCopy(t.Path, filepath.Join(sstDir, sstable.FormatFilename(t.ID))); err != nil {
+				return err
+			}
+		}
+	}
+	for i, num := range logs {
+		if err := copyFile(d.logPath(num), filepath.Join(dir, wal.FormatFilename(num)), sizes[i]); err != nil {
+			return err
+		}
+	}
+	man, err := manifest.Create(dir, manNum, st)
+	if err != nil {
+		return err
+	}
+	return man.Close()
+}
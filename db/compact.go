@@ -0,0 +1,171 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ChinmayNoob/lsm-go/compaction"
+	"github.com/ChinmayNoob/lsm-go/manifest"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+)
+
+func (d *DB) newFileNumLocked() uint64 {
+	if d.nextFile == 0 {
+		d.nextFile = 1
+	}
+	id := d.nextFile
+	d.nextFile++
+	return id
+}
+
+// findTable returns the table of a sorted, non-overlapping level whose range
+// covers key, if any.
+func findTable(tables []*sstable.Table, key []byte) *sstable.Table {
+	i := sort.Search(len(tables), func(i int) bool {
+		return bytes.Compare(tables[i].Largest(), key) >= 0
+	})
+	if i == len(tables) || bytes.Compare(tables[i].Smallest(), key) > 0 {
+		return nil
+	}
+	return tables[i]
+}
+
+func (d *DB) levelOptions() compaction.LevelOptions {
+	return compaction.LevelOptions{
+		L0Trigger:      d.opts.MaxSSTTables,
+		BaseLevelBytes: d.opts.BaseLevelBytes,
+		Multiplier:     d.opts.LevelMultiplier,
+	}
+}
+
+// compactLocked runs c and commits its outputs. Merging happens with d.mu
+// released; only the compaction worker changes levels other than L0, and
+// flushes only append to L0, so c's inputs stay in place meanwhile.
+func (d *DB) compactLocked(c *compaction.Compaction) error {
+	out := c.OutputLevel()
+	if c.IsTrivialMove() {
+		t := c.Inputs[0][0]
+		if d.opts.Verbose {
+			fmt.Fprintf(os.Stderr, "[compact] moving SSTable-%06d L%d -> L%d\n", t.ID, c.Level, out)
+		}
+		if err := d.logAndApplyLocked(&manifest.VersionEdit{
+			Removed: []manifest.FileMeta{{Level: c.Level, Num: t.ID}},
+			Added:   []manifest.FileMeta{{Level: out, Num: t.ID, GlobalSeq: t.GlobalSeq()}},
+		}); err != nil {
+			return err
+		}
+		d.installLevelsLocked(c.Apply(&d.current.levels, c.Inputs[0]))
+		return nil
+	}
+
+	if d.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "[compact] merging %d SSTables from L%d with %d from L%d...\n",
+			len(c.Inputs[0]), c.Level, len(c.Inputs[1]), out)
+	}
+	inputs := append(append([]*sstable.Table(nil), c.Inputs[0]...), c.Inputs[1]...)
+	// Snapshots taken while merging are newer than anything in the inputs,
+	// so the smallest one can only grow.
+	var nums []uint64
+	cfg := compaction.Config{
+		Dir:              d.sstDir,
+		SmallestSnapshot: d.smallestSnapshotLocked(),
+		NewestSnapshot:   d.newestSnapshotLocked(),
+		TargetFileSize:   d.opts.TargetFileSize,
+		Compression:      d.opts.Compression,
+		PrefixExtractor:  d.opts.PrefixExtractor,
+		MergeOperator:    d.opts.MergeOperator,
+		Filter:           d.opts.CompactionFilter,
+		Level:            out,
+		Reader:           d.readerOptions(),
+		Now:              time.Now().UnixNano(),
+		Below:            d.current.levels[out+1:],
+		NewFileNum: func() uint64 {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			num := d.newFileNumLocked()
+			d.pending[num] = true
+			nums = append(nums, num)
+			return num
+		},
+	}
+	d.compacting = true
+	d.mu.Unlock()
+	outputs, stats, err := compaction.Run(inputs, cfg)
+	d.mu.Lock()
+	d.compacting = false
+	defer func() {
+		for _, num := range nums {
+			delete(d.pending, num)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	edit := &manifest.VersionEdit{}
+	for i, in := range c.Inputs {
+		for _, t := range in {
+			edit.Removed = append(edit.Removed, manifest.FileMeta{Level: c.Level + i, Num: t.ID})
+		}
+	}
+	for _, t := range outputs {
+		edit.Added = append(edit.Added, manifest.FileMeta{Level: out, Num: t.ID})
+	}
+	if err := d.logAndApplyLocked(edit); err != nil {
+		// Outputs are left for the next recovery to keep or collect,
+		// depending on whether the edit made it to disk.
+		return err
+	}
+	d.installLevelsLocked(c.Apply(&d.current.levels, outputs))
+	d.lastCompactStats = stats
+	d.compactStats.TombstonesDropped += stats.TombstonesDropped
+	d.compactStats.RangeTombstonesDropped += stats.RangeTombstonesDropped
+	d.compactStats.ExpiredDropped += stats.ExpiredDropped
+	d.compactStats.MergeOperandsFolded += stats.MergeOperandsFolded
+	d.compactStats.FilterKept += stats.FilterKept
+	d.compactStats.FilterRemoved += stats.FilterRemoved
+	d.compactStats.FilterChanged += stats.FilterChanged
+	if d.opts.Verbose {
+		for _, t := range outputs {
+			fmt.Fprintf(os.Stderr, "[compact] created SSTable-%06d in L%d (with Bloom filter)\n", t.ID, out)
+		}
+		fmt.Fprintf(os.Stderr, "[compact] dropped %d tombstones, %d range tombstones, %d expired values\n",
+			stats.TombstonesDropped, stats.RangeTombstonesDropped, stats.ExpiredDropped)
+		if stats.MergeOperandsFolded > 0 {
+			fmt.Fprintf(os.Stderr, "[compact] folded %d merge operands\n", stats.MergeOperandsFolded)
+		}
+		if f := cfg.Filter; f != nil {
+			fmt.Fprintf(os.Stderr, "[compact] filter %s: kept %d, removed %d, changed %d\n",
+				f.Name(), stats.FilterKept, stats.FilterRemoved, stats.FilterChanged)
+		}
+	}
+
+	// The manifest no longer lists the inputs; they go once the last
+	// reader of an older version is done with them.
+	d.obsolete = append(d.obsolete, inputs...)
+	d.deleteObsoleteTablesLocked()
+	return nil
+}
+
+// installLevelsLocked installs a version with the given tables.
+func (d *DB) installLevelsLocked(levels compaction.Levels) {
+	v := d.current.clone()
+	v.levels = levels
+	d.installLocked(v)
+}
+
+// CompactionStats returns the totals of every compaction since Open.
+func (d *DB) CompactionStats() compaction.Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.compactStats
+}
+
+// LastCompactionStats returns what the latest compaction since Open did.
+func (d *DB) LastCompactionStats() compaction.Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastCompactStats
+}
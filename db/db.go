@@ -1,48 +1,83 @@
 package db
 
 import (
+	"container/list"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
-	"strconv"
-	"strings"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/ChinmayNoob/lsm-go/cache"
 	"github.com/ChinmayNoob/lsm-go/compaction"
-	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/manifest"
 	"github.com/ChinmayNoob/lsm-go/sstable"
 	"github.com/ChinmayNoob/lsm-go/wal"
 )
 
 var (
-	ErrClosed   = errors.New("db is closed")
-	ErrEmptyKey = errors.New("empty key")
+	ErrClosed           = errors.New("db is closed")
+	ErrEmptyKey         = errors.New("empty key")
+	ErrInvalidRange     = errors.New("invalid range: start must be less than end")
+	ErrSnapshotReleased = errors.New("snapshot released")
+	ErrNoMergeOperator  = errors.New("merge needs Options.MergeOperator")
 )
 
 type DB struct {
 	mu     sync.Mutex
 	closed bool
 
-	mem *memtable.Memtable
-	seq uint64
+	current  *version              // see version
+	versions map[*version]struct{} // versions with refs > 0
+	obsolete []*sstable.Table      // replaced tables some version still lists
+	memLog   uint64                // oldest WAL holding writes in current.mem
+	seq      uint64
 
-	opts    Options
-	walPath string
-	w       *wal.WAL
+	snapshots list.List // live *Snapshot, oldest first
+
+	opts   Options
+	w      *wal.WAL
+	logNum uint64 // file number of w
+	man    *manifest.Manifest
 
 	memBytes int
 
-	sstDir   string
-	nextSST  uint64
-	sstables []*sstable.Table // sorted by ID ascending
+	sstDir           string
+	nextFile         uint64                       // shared by WALs, tables and manifests
+	pointers         [compaction.NumLevels][]byte // compaction round-robin, see compaction.Pick
+	compactStats     compaction.Stats             // totals since Open
+	lastCompactStats compaction.Stats             // stats of the latest compaction
+	bcache           *cache.Cache                 // nil when BlockCacheBytes <= 0
+	files            *cache.Files                 // nil when MaxOpenFiles <= 0
+
+	writers []*writer // queued writes; the first one is the leader, see Write
+
+	bgCond  *sync.Cond      // on mu; signalled when background state changes
+	bgWG    sync.WaitGroup  // flush and compaction workers
+	bgErr   error           // first background or WAL failure; fails later writes
+	pending map[uint64]bool // tables being written, kept from file GC
+	logRefs map[uint64]int  // WALs checkpoints are copying, kept from file GC
+	// compacting is set while a compaction merges with mu released.
+	compacting bool
+	ingests    int // ingestions in progress; no compaction starts meanwhile
 }
 
 func Open(opts Options) (*DB, error) {
 	if opts.Dir == "" {
 		opts.Dir = "."
 	}
+	def := DefaultOptions()
+	if opts.BaseLevelBytes <= 0 {
+		opts.BaseLevelBytes = def.BaseLevelBytes
+	}
+	if opts.LevelMultiplier <= 1 {
+		opts.LevelMultiplier = def.LevelMultiplier
+	}
+	if opts.MaxImmutableMemtables <= 0 {
+		opts.MaxImmutableMemtables = def.MaxImmutableMemtables
+	}
 	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
 		return nil, err
 	}
@@ -51,344 +86,298 @@ func Open(opts Options) (*DB, error) {
 	if err := os.MkdirAll(sstDir, 0o755); err != nil {
 		return nil, err
 	}
-	// Cleanup leftover tmp files.
-	if err := cleanupTmpFiles(sstDir); err != nil {
-		return nil, err
-	}
-
 	d := &DB{
-		opts:    opts,
-		mem:     memtable.New(),
-		seq:     1,
-		walPath: filepath.Join(opts.Dir, "wal.log"),
-		sstDir:  sstDir,
+		opts:     opts,
+		versions: make(map[*version]struct{}),
+		seq:      1,
+		sstDir:   sstDir,
+		pending:  make(map[uint64]bool),
+		logRefs:  make(map[uint64]int),
 	}
-
-	// Replay WAL into memtable (if present).
-	maxSeq, err := wal.Replay(d.walPath, func(r wal.Record) error {
-		switch r.Op {
-		case wal.OpPut:
-			d.mem.Apply(memtable.Record{
-				Key:   r.Key,
-				Value: r.Value,
-				Seq:   r.Seq,
-			})
-		case wal.OpDelete:
-			d.mem.Apply(memtable.Record{
-				Key:       r.Key,
-				Tombstone: true,
-				Seq:       r.Seq,
-			})
-		default:
-			return wal.ErrCorrupt
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
+	d.bgCond = sync.NewCond(&d.mu)
+	if opts.BlockCacheBytes > 0 {
+		d.bcache = cache.New(opts.BlockCacheBytes)
 	}
-	d.seq = maxSeq + 1
-
-	// Load existing SSTables (minimal manifest).
-	tables, nextID, err := loadSSTables(d.sstDir)
-	if err != nil {
-		return nil, err
+	if opts.MaxOpenFiles > 0 {
+		d.files = cache.NewFiles(opts.MaxOpenFiles)
 	}
-	d.sstables = tables
-	d.nextSST = nextID
 
-	ww, err := wal.Open(d.walPath, opts.SyncOnWrite)
-	if err != nil {
+	if err := d.recoverLocked(); err != nil {
+		if d.w != nil {
+			_ = d.w.Close()
+		}
+		d.closeFilesLocked()
 		return nil, err
 	}
-	d.w = ww
+	d.startBackgroundLocked()
 	return d, nil
 }
 
 func (d *DB) Put(key, value []byte) error {
-	if len(key) == 0 {
-		return ErrEmptyKey
-	}
-	if value == nil {
-		// Treat nil as empty; keeps semantics simple for beginners.
-		value = []byte{}
-	}
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return ErrClosed
-	}
-	seq := d.seq
-	d.seq++
-	if err := d.w.Append(wal.OpPut, seq, key, value); err != nil {
-		return err
-	}
-	d.mem.Apply(memtable.Record{
-		Key:   key,
-		Value: value,
-		Seq:   seq,
-	})
-	d.memBytes += approxRecordBytes(key, value)
-	if err := d.maybeFlushLocked(); err != nil {
-		return err
-	}
-	return nil
+	var b WriteBatch
+	b.Put(key, value)
+	return d.Write(&b)
 }
 
 func (d *DB) Delete(key []byte) error {
-	if len(key) == 0 {
-		return ErrEmptyKey
-	}
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return ErrClosed
-	}
-	seq := d.seq
-	d.seq++
-	if err := d.w.Append(wal.OpDelete, seq, key, nil); err != nil {
-		return err
-	}
-	d.mem.Apply(memtable.Record{
-		Key:       key,
-		Tombstone: true,
-		Seq:       seq,
-	})
-	d.memBytes += approxRecordBytes(key, nil)
-	if err := d.maybeFlushLocked(); err != nil {
-		return err
-	}
-	return nil
+	var b WriteBatch
+	b.Delete(key)
+	return d.Write(&b)
+}
+
+// PutWithTTL writes a value that expires after ttl: from then on reads treat
+// it as deleted, and compaction drops it like a tombstone. A ttl <= 0 writes
+// a value that has already expired.
+func (d *DB) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	var b WriteBatch
+	b.PutWithTTL(key, value, ttl)
+	return d.Write(&b)
+}
+
+// Merge records operand for key without reading it: Options.MergeOperator
+// combines the operands with the value written before them when key is
+// read, and compaction folds them into it once no reader needs them apart.
+func (d *DB) Merge(key, operand []byte) error {
+	var b WriteBatch
+	b.Merge(key, operand)
+	return d.Write(&b)
+}
+
+// DeleteRange deletes every key in [start, end) with one range tombstone,
+// however many keys the range holds.
+func (d *DB) DeleteRange(start, end []byte) error {
+	var b WriteBatch
+	b.DeleteRange(start, end)
+	return d.Write(&b)
 }
 
 // Get returns (value, ok, err).
 //
-// ok=false means key not found (or deleted by tombstone).
+// ok=false means key not found (or deleted by a tombstone or range tombstone,
+// or expired).
 func (d *DB) Get(key []byte) ([]byte, bool, error) {
 	if len(key) == 0 {
 		return nil, false, ErrEmptyKey
 	}
 	d.mu.Lock()
-	defer d.mu.Unlock()
 	if d.closed {
+		d.mu.Unlock()
 		return nil, false, ErrClosed
 	}
-	r, ok := d.mem.Get(key)
-	if ok {
+	v := d.refLocked()
+	seq := d.seq - 1
+	d.mu.Unlock()
+	defer d.unref(v)
+	return d.get(v, key, seq)
+}
+
+// get returns the newest version of key with Seq <= seq in v, with the merge
+// operands written on top of it resolved. It runs without d.mu.
+func (d *DB) get(v *version, key []byte, seq uint64) ([]byte, bool, error) {
+	// A range tombstone may sit in any memtable or table, so find the newest
+	// one covering key first; it hides every older version found below.
+	rangeSeq := v.rangeDelSeq(key, seq)
+	now := time.Now().UnixNano()
+	// A merge operand settles nothing: the lookup goes on below it, and the
+	// operands found on the way (newest first) are applied to what it ends at.
+	var operands [][]byte
+	for {
+		r, ok := v.mem.GetAt(key, seq)
+		if !ok {
+			break
+		}
 		if d.opts.Verbose {
 			fmt.Fprintf(os.Stderr, "[get] found in memtable\n")
 		}
-		if r.Tombstone {
-			return nil, false, nil
+		if r.Merge && r.Seq >= rangeSeq {
+			operands = append(operands, r.Value)
+			seq = r.Seq - 1
+			continue
+		}
+		if r.Tombstone || r.Seq < rangeSeq || r.Expired(now) {
+			return d.resolveMerge(key, nil, false, operands)
+		}
+		return d.resolveMerge(key, r.Value, true, operands)
+	}
+	for i := len(v.imm) - 1; i >= 0; i-- {
+		for {
+			r, ok := v.imm[i].mem.GetAt(key, seq)
+			if !ok {
+				break
+			}
+			if d.opts.Verbose {
+				fmt.Fprintf(os.Stderr, "[get] found in immutable memtable\n")
+			}
+			if r.Merge && r.Seq >= rangeSeq {
+				operands = append(operands, r.Value)
+				seq = r.Seq - 1
+				continue
+			}
+			if r.Tombstone || r.Seq < rangeSeq || r.Expired(now) {
+				return d.resolveMerge(key, nil, false, operands)
+			}
+			return d.resolveMerge(key, r.Value, true, operands)
 		}
-		return r.Value, true, nil
 	}
 	if d.opts.Verbose {
-		fmt.Fprintf(os.Stderr, "[get] not in memtable, checking %d SSTables...\n", len(d.sstables))
+		fmt.Fprintf(os.Stderr, "[get] not in memtable, checking %d SSTables...\n", v.numTables())
 	}
 
-	// SSTables: newest to oldest.
-	for i := len(d.sstables) - 1; i >= 0; i-- {
-		tbl := d.sstables[i]
-		if !tbl.MaybeContains(key) {
-			if d.opts.Verbose {
-				fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: skipped (key not present)\n", tbl.ID)
+	// L0 newest to oldest, then at most one table per deeper level.
+	for level, tables := range v.levels {
+		var probe []*sstable.Table
+		if level == 0 {
+			probe = make([]*sstable.Table, 0, len(tables))
+			for i := len(tables) - 1; i >= 0; i-- {
+				probe = append(probe, tables[i])
 			}
-			continue
+		} else if t := findTable(tables, key); t != nil {
+			probe = []*sstable.Table{t}
 		}
-		if d.opts.Verbose {
-			fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: maybe present, checking...\n", tbl.ID)
-		}
-		rec, ok, err := tbl.Get(key)
-		if err != nil {
-			return nil, false, err
-		}
-		if !ok {
-			if d.opts.Verbose {
-				fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: false positive (not found after check)\n", tbl.ID)
+		for _, tbl := range probe {
+			if !tbl.MaybeContains(key) {
+				if d.opts.Verbose {
+					fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: skipped (key not present)\n", tbl.ID)
+				}
+				continue
 			}
-			continue
-		}
-		if rec.Tombstone {
 			if d.opts.Verbose {
-				fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: found tombstone\n", tbl.ID)
+				fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: maybe present, checking...\n", tbl.ID)
+			}
+			for {
+				rec, ok, err := tbl.GetAt(key, seq)
+				if err != nil {
+					return nil, false, err
+				}
+				if !ok {
+					if d.opts.Verbose && len(operands) == 0 {
+						fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: false positive (not found after check)\n", tbl.ID)
+					}
+					break
+				}
+				if rec.Merge && rec.Seq >= rangeSeq {
+					if d.opts.Verbose {
+						fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: found merge operand\n", tbl.ID)
+					}
+					operands = append(operands, rec.Value)
+					seq = rec.Seq - 1
+					continue
+				}
+				if rec.Tombstone {
+					if d.opts.Verbose {
+						fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: found tombstone\n", tbl.ID)
+					}
+					return d.resolveMerge(key, nil, false, operands)
+				}
+				if rec.Expired(now) {
+					if d.opts.Verbose {
+						fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: found expired value\n", tbl.ID)
+					}
+					return d.resolveMerge(key, nil, false, operands)
+				}
+				if rec.Seq < rangeSeq {
+					if d.opts.Verbose {
+						fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: found value, deleted by range tombstone\n", tbl.ID)
+					}
+					return d.resolveMerge(key, nil, false, operands)
+				}
+				if d.opts.Verbose {
+					fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: found value\n", tbl.ID)
+				}
+				return d.resolveMerge(key, rec.Value, true, operands)
 			}
-			return nil, false, nil
-		}
-		if d.opts.Verbose {
-			fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: found value\n", tbl.ID)
 		}
-		return rec.Value, true, nil
 	}
 
-	if d.opts.Verbose {
+	if d.opts.Verbose && len(operands) == 0 {
 		fmt.Fprintf(os.Stderr, "[get] key not found in any SSTable\n")
 	}
-	return nil, false, nil
+	return d.resolveMerge(key, nil, false, operands)
 }
 
-func (d *DB) Close() error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return nil
-	}
-	if d.w != nil {
-		if err := d.w.Close(); err != nil {
-			return err
-		}
-	}
-	d.closed = true
-	return nil
-}
-
-func (d *DB) maybeFlushLocked() error {
-	if d.opts.MemtableMaxBytes <= 0 {
-		return nil
-	}
-	if d.memBytes < d.opts.MemtableMaxBytes {
-		return nil
-	}
-
-	// Rotate WAL safely: keep old WAL until flush succeeds.
-	oldWALPath := d.walPath + fmt.Sprintf(".old-%d", d.seq)
-	if err := d.w.Close(); err != nil {
-		return err
-	}
-	if err := os.Rename(d.walPath, oldWALPath); err != nil {
-		return err
+// resolveMerge returns the value of key given the version a lookup ended at
+// (value, or none if !ok) and the merge operands above it, newest first.
+func (d *DB) resolveMerge(key, value []byte, ok bool, operands [][]byte) ([]byte, bool, error) {
+	if len(operands) == 0 {
+		return value, ok, nil
 	}
-	newW, err := wal.Open(d.walPath, d.opts.SyncOnWrite)
-	if err != nil {
-		_ = os.Rename(oldWALPath, d.walPath)
-		return err
-	}
-
-	immutable := d.mem
-	keys := immutable.KeysSorted()
-
-	// Swap to new memtable + WAL.
-	d.mem = memtable.New()
-	d.memBytes = 0
-	d.w = newW
-
-	// Flush immutable memtable to SSTable.
-	id := d.nextSST
-	if id == 0 {
-		id = 1
+	if d.opts.MergeOperator == nil {
+		return nil, false, ErrNoMergeOperator
 	}
-	d.nextSST = id + 1
-	sstPath := filepath.Join(d.sstDir, sstable.FormatFilename(id))
-	if d.opts.Verbose {
-		fmt.Fprintf(os.Stderr, "[flush] flushing memtable (%d keys) to SSTable-%06d\n", len(keys), id)
-	}
-	if err := sstable.Build(sstPath, keys, immutable, 16); err != nil {
-		return err
-	}
-	tbl, err := sstable.Open(sstPath, id)
+	slices.Reverse(operands)
+	merged, err := d.opts.MergeOperator.Merge(key, value, operands)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
-	d.sstables = append(d.sstables, tbl)
-	sort.Slice(d.sstables, func(i, j int) bool { return d.sstables[i].ID < d.sstables[j].ID })
-	if d.opts.Verbose {
-		fmt.Fprintf(os.Stderr, "[flush] SSTable-%06d created (with Bloom filter)\n", id)
-	}
-
-	// Delete old WAL now that its contents are safely persisted.
-	_ = os.Remove(oldWALPath)
+	return merged, true, nil
+}
 
-	// Optional compaction trigger.
-	if d.opts.MaxSSTTables > 0 && len(d.sstables) > d.opts.MaxSSTTables {
-		return d.compactLocked()
-	}
-	return nil
+func (d *DB) readerOptions() sstable.ReaderOptions {
+	return sstable.ReaderOptions{BlockCache: d.bcache, Files: d.files}
 }
 
-func approxRecordBytes(key, value []byte) int {
-	return len(key) + len(value) + 32
+func (d *DB) writerOptions() sstable.WriterOptions {
+	return sstable.WriterOptions{Compression: d.opts.Compression, PrefixExtractor: d.opts.PrefixExtractor}
 }
 
-func loadSSTables(dir string) ([]*sstable.Table, uint64, error) {
-	ents, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, 1, err
+// evictTable drops a deleted table from the block and file caches.
+func (d *DB) evictTable(id uint64) {
+	if d.bcache != nil {
+		d.bcache.EvictTable(id)
 	}
-	type pair struct {
-		id   uint64
-		path string
+	if d.files != nil {
+		d.files.Evict(id)
 	}
-	var ps []pair
-	var maxID uint64
-	for _, e := range ents {
-		if e.IsDir() {
-			continue
-		}
-		name := e.Name()
-		if !strings.HasPrefix(name, "sstable-") || !strings.HasSuffix(name, ".sst") {
-			continue
-		}
-		idStr := strings.TrimSuffix(strings.TrimPrefix(name, "sstable-"), ".sst")
-		id64, err := strconv.ParseUint(idStr, 10, 64)
-		if err != nil {
-			continue
-		}
-		if id64 > maxID {
-			maxID = id64
-		}
-		ps = append(ps, pair{id: id64, path: filepath.Join(dir, name)})
-	}
-	sort.Slice(ps, func(i, j int) bool { return ps[i].id < ps[j].id })
-	out := make([]*sstable.Table, 0, len(ps))
-	for _, p := range ps {
-		t, err := sstable.Open(p.path, p.id)
-		if err != nil {
-			return nil, 1, err
-		}
-		out = append(out, t)
-	}
-	return out, maxID + 1, nil
 }
 
-func cleanupTmpFiles(dir string) error {
-	ents, err := os.ReadDir(dir)
-	if err != nil {
-		return err
-	}
-	for _, e := range ents {
-		if e.IsDir() {
-			continue
-		}
-		if strings.HasSuffix(e.Name(), ".tmp") {
-			_ = os.Remove(filepath.Join(dir, e.Name()))
-		}
+// BlockCacheStats reports the block cache hit/miss counters and usage. All
+// counters are zero when the cache is disabled.
+func (d *DB) BlockCacheStats() cache.Stats {
+	if d.bcache == nil {
+		return cache.Stats{}
 	}
-	return nil
+	return d.bcache.Stats()
 }
 
-func (d *DB) compactLocked() error {
-	if len(d.sstables) <= 1 {
+// Close waits for queued flushes and the compactions they trigger to finish,
+// then releases the DB's files. It returns the first background error, if
+// any.
+func (d *DB) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
 		return nil
 	}
-	if d.opts.Verbose {
-		fmt.Fprintf(os.Stderr, "[compact] merging %d SSTables...\n", len(d.sstables))
-	}
-	outID := d.nextSST
-	if outID == 0 {
-		outID = 1
+	d.closed = true
+	d.bgCond.Broadcast()
+	for len(d.writers) > 0 {
+		d.bgCond.Wait()
 	}
-	d.nextSST = outID + 1
+	d.mu.Unlock()
+	d.bgWG.Wait()
 
-	newTbl, err := compaction.Run(d.sstDir, d.sstables, outID)
-	if err != nil {
-		return err
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.bgErr
+	if d.w != nil {
+		if werr := d.w.Close(); err == nil {
+			err = werr
+		}
 	}
-	if newTbl == nil {
-		return nil
+	d.closeFilesLocked()
+	return err
+}
+
+// closeFilesLocked releases the manifest and cached table files.
+func (d *DB) closeFilesLocked() {
+	if d.man != nil {
+		_ = d.man.Close()
 	}
-	if d.opts.Verbose {
-		fmt.Fprintf(os.Stderr, "[compact] created SSTable-%06d (with Bloom filter)\n", outID)
+	if d.files != nil {
+		d.files.Close()
 	}
-	d.sstables = []*sstable.Table{newTbl}
-	return nil
+}
+
+func approxRecordBytes(key, value []byte) int {
+	return len(key) + len(value) + 32
 }
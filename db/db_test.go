@@ -0,0 +1,67 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// An expired value reads as deleted, hiding older versions of its key, and
+// compaction drops it.
+func TestPutWithTTL(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Dir = t.TempDir()
+	opts.MemtableMaxBytes = 1 // every write is flushed to a table
+	opts.MaxSSTTables = 2
+	d := openTestDB(t, opts)
+	if err := d.Put([]byte("a"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []struct {
+		key string
+		ttl time.Duration
+	}{
+		{"a", -time.Second},
+		{"b", time.Hour},
+		{"c", 0},
+	} {
+		if err := d.PutWithTTL([]byte(w.key), []byte("v"+w.key), w.ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func() {
+		t.Helper()
+		want := map[string]string{"b": "vb"}
+		for _, k := range []string{"a", "b", "c"} {
+			v, ok, err := d.Get([]byte(k))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, live := want[k]; ok != live || string(v) != w {
+				t.Errorf("Get(%s) = %q, %v; want %q, %v", k, v, ok, w, live)
+			}
+		}
+		it, err := d.NewIterator(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = it.Close() }()
+		for _, reverse := range []bool{false, true} {
+			if got := collect(t, it, reverse); fmt.Sprint(got) != "[b=vb]" {
+				t.Errorf("iterator reverse=%v = %q, want [b=vb]", reverse, got)
+			}
+		}
+	}
+	check()
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := d.CompactionStats(); st.ExpiredDropped == 0 {
+		t.Errorf("no expired value was dropped: %+v", st)
+	}
+
+	d = openTestDB(t, opts)
+	defer func() { _ = d.Close() }()
+	check()
+}
@@ -0,0 +1,287 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ChinmayNoob/lsm-go/compaction"
+	"github.com/ChinmayNoob/lsm-go/manifest"
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+)
+
+var ErrInvalidIngest = errors.New("cannot ingest file")
+
+// IngestExternalFiles adds SSTables built with sstable.Writer to the DB
+// without going through the WAL or the memtable. Each file gets the next
+// sequence number, which every entry in it reads with, so it is newer than
+// everything written before; later files win over earlier ones. A file goes
+// to the deepest level that no table above it overlaps, or to L0.
+//
+// Since all of a file's entries read at one sequence number, a file can hold
+// only one version of each key: a single put or delete. Files with several
+// versions of a key are rejected with ErrInvalidIngest.
+//
+// The files are hard-linked into the DB (copied if that fails) and must not
+// be modified afterwards; the caller may remove them. Either all files are
+// ingested or none is.
+func (d *DB) IngestExternalFiles(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	for _, p := range paths {
+		if err := checkExternalFile(p); err != nil {
+			return err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+
+	// Stage the files next to the tables, kept from file GC until committed.
+	staged := make([]string, len(paths))
+	for i := range paths {
+		num := d.newFileNumLocked()
+		d.pending[num] = true
+		defer delete(d.pending, num)
+		staged[i] = filepath.Join(d.sstDir, sstable.FormatFilename(num)+".tmp")
+	}
+	defer func() {
+		for _, p := range staged {
+			_ = os.Remove(p) // gone already if committed
+		}
+	}()
+	d.mu.Unlock()
+	err := func() error {
+		for i, p := range paths {
+			if err := linkOrCopy(p, staged[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	d.mu.Lock()
+	if err != nil {
+		return err
+	}
+
+	// A compaction could install outputs around the level an ingested table
+	// goes to. Keep new ones from starting and wait out the running one
+	// before stopping the writes, which it could hold up for long.
+	d.ingests++
+	defer func() {
+		d.ingests--
+		d.bgCond.Broadcast()
+	}()
+	for d.compacting && d.bgErr == nil {
+		d.bgCond.Wait()
+	}
+
+	// Take the head of the write queue so no write group runs meanwhile.
+	d.leadWriteQueueLocked()
+	defer d.popWritersLocked(1)
+	return d.ingestLocked(staged)
+}
+
+// ingestLocked commits the staged files. It runs at the head of the write
+// queue, with no compaction running.
+func (d *DB) ingestLocked(staged []string) error {
+	if d.closed {
+		return ErrClosed
+	}
+	if d.bgErr != nil {
+		return d.bgErr
+	}
+
+	// Reads take a key from a memtable before looking at any table, so no
+	// memtable may hold an older version of a key ingested now: flush the
+	// ones that overlap first.
+	var bounds [][2][]byte
+	for _, p := range staged {
+		t, err := sstable.Open(p, 0, sstable.ReaderOptions{})
+		if err != nil {
+			return err
+		}
+		bounds = append(bounds, [2][]byte{t.Smallest(), t.Largest()})
+	}
+	overlaps := func(mem *memtable.Memtable) bool {
+		for _, b := range bounds {
+			if memOverlaps(mem, b[0], b[1]) {
+				return true
+			}
+		}
+		return false
+	}
+	if overlaps(d.current.mem) {
+		if err := d.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	for {
+		if d.bgErr != nil {
+			return d.bgErr
+		}
+		busy := false
+		for _, imm := range d.current.imm {
+			busy = busy || overlaps(imm.mem)
+		}
+		if !busy {
+			break
+		}
+		d.bgCond.Wait()
+	}
+
+	v := d.current.clone()
+	edit := &manifest.VersionEdit{}
+	var added []*sstable.Table
+	fail := func(err error) error {
+		for _, t := range added {
+			_ = os.Remove(t.Path)
+		}
+		return err
+	}
+	for _, p := range staged {
+		num, seq := d.newFileNumLocked(), d.seq
+		d.seq++
+		path := filepath.Join(d.sstDir, sstable.FormatFilename(num))
+		if err := os.Rename(p, path); err != nil {
+			return fail(err)
+		}
+		t, err := sstable.OpenIngested(path, num, seq, d.readerOptions())
+		if err != nil {
+			_ = os.Remove(path)
+			return fail(err)
+		}
+		added = append(added, t)
+		level := ingestLevel(&v.levels, t.Smallest(), t.Largest())
+		v.levels[level] = append(v.levels[level], t)
+		if level > 0 {
+			compaction.SortLevel(v.levels[level])
+		}
+		edit.Added = append(edit.Added, manifest.FileMeta{Level: level, Num: num, GlobalSeq: seq})
+		if d.opts.Verbose {
+			fmt.Fprintf(os.Stderr, "[ingest] SSTable-%06d in L%d at seq %d\n", num, level, seq)
+		}
+	}
+	if err := d.logAndApplyLocked(edit); err != nil {
+		return fail(err)
+	}
+	d.installLocked(v)
+	d.bgCond.Broadcast() // L0 may need compacting
+	return nil
+}
+
+// ingestLevel returns the deepest level a table over [smallest, largest] can
+// go to: neither that level nor any above it may overlap the table, since
+// the table is newer than all of them. L0 takes it otherwise.
+func ingestLevel(levels *compaction.Levels, smallest, largest []byte) int {
+	if len(compaction.Overlapping(levels[0], smallest, largest)) > 0 {
+		return 0
+	}
+	level := 0
+	for l := 1; l < compaction.NumLevels; l++ {
+		if len(compaction.Overlapping(levels[l], smallest, largest)) > 0 {
+			break
+		}
+		level = l
+	}
+	return level
+}
+
+// memOverlaps reports whether mem holds a key or range tombstone within
+// [smallest, largest].
+func memOverlaps(mem *memtable.Memtable, smallest, largest []byte) bool {
+	it := mem.NewIterator()
+	if it.SeekGE(smallest); it.Valid() && bytes.Compare(it.Record().Key, largest) <= 0 {
+		return true
+	}
+	for _, t := range mem.RangeDels().Tombstones() {
+		if t.Overlaps(smallest, largest) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkExternalFile reads a table to be ingested through, which verifies
+// every block checksum, and checks that it holds something, that no key is
+// empty and that no key appears twice.
+func checkExternalFile(path string) error {
+	t, err := sstable.Open(path, 0, sstable.ReaderOptions{})
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidIngest, path, err)
+	}
+	it, err := t.NewIterator()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = it.Close() }()
+	var prev []byte
+	n := 0
+	for it.First(); it.Valid(); it.Next() {
+		key := it.Record().Key
+		if len(key) == 0 {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidIngest, path, ErrEmptyKey)
+		}
+		if n > 0 && bytes.Equal(key, prev) {
+			return fmt.Errorf("%w: %s: key %q appears more than once", ErrInvalidIngest, path, key)
+		}
+		prev = append(prev[:0], key...)
+		n++
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidIngest, path, err)
+	}
+	for _, rd := range t.RangeDels().Tombstones() {
+		if bytes.Compare(rd.Start, rd.End) >= 0 {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidIngest, path, ErrInvalidRange)
+		}
+	}
+	if n == 0 && t.RangeDels().Len() == 0 {
+		return fmt.Errorf("%w: %s: table is empty", ErrInvalidIngest, path)
+	}
+	return nil
+}
+
+// linkOrCopy hard-links src to dst, or copies it if the two can't share a
+// link (e.g. different file systems).
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst, -1)
+}
+
+// copyFile copies the first n bytes of src to dst and syncs it; a negative n
+// copies all of src.
+func copyFile(src, dst string, n int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = in
+	if n >= 0 {
+		r = io.LimitReader(in, n)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ChinmayNoob/lsm-go/compaction"
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+)
+
+// writeExternalTable builds a table for ingestion from recs, which must be
+// in order.
+func writeExternalTable(t *testing.T, path string, recs ...memtable.Record) string {
+	t.Helper()
+	w, err := sstable.NewWriter(path, sstable.WriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range recs {
+		if err := w.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIngestRejectsSeveralVersionsOfAKey(t *testing.T) {
+	d := openTestDB(t, DefaultOptions())
+	defer func() { _ = d.Close() }()
+	path := writeExternalTable(t, filepath.Join(t.TempDir(), "ext.sst"),
+		memtable.Record{Key: []byte("k"), Value: []byte("new"), Seq: 2},
+		memtable.Record{Key: []byte("k"), Value: []byte("old"), Seq: 1},
+	)
+	if err := d.IngestExternalFiles([]string{path}); !errors.Is(err, ErrInvalidIngest) {
+		t.Fatalf("err = %v, want ErrInvalidIngest", err)
+	}
+}
+
+// Ingestion waits for a running compaction before it stops the writes.
+func TestIngestWaitsForCompactionWithoutStallingWrites(t *testing.T) {
+	d := openTestDB(t, DefaultOptions())
+	defer func() { _ = d.Close() }()
+	path := writeExternalTable(t, filepath.Join(t.TempDir(), "ext.sst"),
+		memtable.Record{Key: []byte("x"), Value: []byte("ingested")})
+
+	setCompacting := func(on bool) {
+		d.mu.Lock()
+		d.compacting = on
+		d.bgCond.Broadcast()
+		d.mu.Unlock()
+	}
+	setCompacting(true)
+	ingested := make(chan error, 1)
+	go func() { ingested <- d.IngestExternalFiles([]string{path}) }()
+	for waiting := false; !waiting; {
+		d.mu.Lock()
+		waiting = d.ingests > 0
+		d.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	put := make(chan error, 1)
+	go func() { put <- d.Put([]byte("a"), []byte("1")) }()
+	select {
+	case err := <-put:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		setCompacting(false)
+		t.Fatal("Put waited for the compaction")
+	}
+	select {
+	case err := <-ingested:
+		t.Fatalf("ingestion didn't wait for the compaction: %v", err)
+	default:
+	}
+
+	setCompacting(false)
+	if err := <-ingested; err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := d.Get([]byte("x")); string(v) != "ingested" {
+		t.Fatalf("Get(x) = %q, want ingested", v)
+	}
+}
+
+// tableLevels maps the number of every table in the current version to its
+// level.
+func tableLevels(d *DB) map[uint64]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	levels := map[uint64]int{}
+	for level, tables := range d.current.levels {
+		for _, t := range tables {
+			levels[t.ID] = level
+		}
+	}
+	return levels
+}
+
+// Each file goes to the deepest level nothing above it overlaps, reads newer
+// than whatever it overlaps, and keeps both across a reopen.
+func TestIngestLevelsAndReopen(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Dir = t.TempDir()
+	d := openTestDB(t, opts)
+	ext := t.TempDir()
+	rec := func(key, value string) memtable.Record {
+		return memtable.Record{Key: []byte(key), Value: []byte(value)}
+	}
+	ingest := func(name string, recs ...memtable.Record) uint64 {
+		t.Helper()
+		before := tableLevels(d)
+		path := writeExternalTable(t, filepath.Join(ext, name), recs...)
+		if err := d.IngestExternalFiles([]string{path}); err != nil {
+			t.Fatal(err)
+		}
+		// A memtable flushed first gets an older number.
+		var added uint64
+		for num := range tableLevels(d) {
+			if _, ok := before[num]; !ok {
+				added = max(added, num)
+			}
+		}
+		if added == 0 {
+			t.Fatalf("%s: no table was added", name)
+		}
+		return added
+	}
+
+	bottom := compaction.NumLevels - 1
+	nums := map[string]uint64{}
+	nums["ac"] = ingest("ac.sst", rec("a", "1"), rec("c", "1"))
+	nums["bd"] = ingest("bd.sst", rec("b", "2"), rec("d", "2"))
+	nums["xy"] = ingest("xy.sst", rec("x", "3"), rec("y", "3"))
+	// The memtable holding c is flushed to L0 first, so the file goes above it.
+	if err := d.Put([]byte("c"), []byte("mem")); err != nil {
+		t.Fatal(err)
+	}
+	nums["c"] = ingest("c.sst", rec("c", "4"))
+	if err := d.Put([]byte("d"), []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"ac": bottom, "bd": bottom - 1, "xy": bottom, "c": 0}
+	wantValues := map[string]string{"a": "1", "b": "2", "c": "4", "d": "after", "x": "3", "y": "3"}
+
+	check := func(when string) {
+		t.Helper()
+		levels := tableLevels(d)
+		for name, level := range want {
+			if got, ok := levels[nums[name]]; !ok || got != level {
+				t.Errorf("%s: %s.sst in L%d (listed %v), want L%d", when, name, got, ok, level)
+			}
+		}
+		for k, w := range wantValues {
+			if v, _, err := d.Get([]byte(k)); err != nil || string(v) != w {
+				t.Errorf("%s: Get(%s) = %q, %v; want %q", when, k, v, err, w)
+			}
+		}
+	}
+	check("after ingesting")
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = openTestDB(t, opts)
+	check("after reopening")
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compacting L0 orders c.sst and the table flushed before it by their
+	// sequence numbers, which the reopen must have kept. Writes after the
+	// reopen are newer still.
+	opts.MemtableMaxBytes = 1
+	opts.MaxSSTTables = 1
+	d = openTestDB(t, opts)
+	if err := d.Put([]byte("a"), []byte("reopened")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil { // waits for the compaction
+		t.Fatal(err)
+	}
+	if level, ok := tableLevels(d)[nums["c"]]; ok {
+		t.Fatalf("c.sst is still in L%d", level)
+	}
+	d = openTestDB(t, opts)
+	defer func() { _ = d.Close() }()
+	wantValues["a"] = "reopened"
+	for k, w := range wantValues {
+		if v, _, err := d.Get([]byte(k)); err != nil || string(v) != w {
+			t.Errorf("after compacting: Get(%s) = %q, %v; want %q", k, v, err, w)
+		}
+	}
+}
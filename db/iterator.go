@@ -0,0 +1,388 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/ChinmayNoob/lsm-go/iterator"
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/merge"
+	"github.com/ChinmayNoob/lsm-go/rangedel"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+)
+
+type direction int
+
+const (
+	forward direction = iota
+	reverse
+)
+
+// Iterator walks live keys in [lower, upper) in ascending key order. It merges
+// the memtables with every SSTable: the newest version of a key wins, merge
+// operands are resolved and deleted keys are skipped. A nil bound means
+// unbounded.
+//
+// While moving forward the internal iterator sits on an entry of the current
+// key, or past them once merge operands were resolved; while moving backward
+// it sits just before all entries of the current key, which is why key and
+// value are kept in separate buffers.
+type Iterator struct {
+	it        iterator.Iterator
+	release   func() // drops the version reference; nil once closed
+	rangeDels *rangedel.List
+	seq       uint64 // only records with Seq <= seq are visible
+	now       int64  // values expired by now (Unix nanoseconds) are hidden
+	lower     []byte
+	upper     []byte
+	merge     merge.Operator
+	err       error // merge failure; stops the iteration
+
+	dir   direction
+	valid bool
+	key   []byte
+	value []byte
+}
+
+// NewIterator returns an iterator over the keys in [lower, upper) as of now;
+// later writes are not visible to it. It must be closed after use.
+func (d *DB) NewIterator(lower, upper []byte) (*Iterator, error) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrClosed
+	}
+	v := d.refLocked()
+	seq := d.seq - 1
+	d.mu.Unlock()
+	return d.newIterator(v, lower, upper, seq, nil)
+}
+
+// NewPrefixIterator returns an iterator over the keys starting with prefix.
+// If prefix is a whole prefix as Options.PrefixExtractor extracts it, tables
+// whose Bloom filter rules it out are not read.
+func (d *DB) NewPrefixIterator(prefix []byte) (*Iterator, error) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrClosed
+	}
+	v := d.refLocked()
+	seq := d.seq - 1
+	d.mu.Unlock()
+	lower, upper := prefix, prefixSuccessor(prefix)
+	return d.newIterator(v, lower, upper, seq, d.prefixFilter(prefix, upper))
+}
+
+// prefixFilter returns which tables a scan of the keys in [prefix, upper)
+// has to read: those overlapping the range, less the ones whose prefix
+// filter rules prefix out.
+func (d *DB) prefixFilter(prefix, upper []byte) func(*sstable.Table) bool {
+	pe := d.opts.PrefixExtractor
+	usable := false
+	if pe != nil {
+		p, ok := pe.Prefix(prefix)
+		usable = ok && bytes.Equal(p, prefix)
+	}
+	return func(t *sstable.Table) bool {
+		if bytes.Compare(t.Largest(), prefix) < 0 || (upper != nil && bytes.Compare(t.Smallest(), upper) >= 0) {
+			return false
+		}
+		if usable && !t.MaybeContainsPrefix(pe, prefix) {
+			if d.opts.Verbose {
+				fmt.Fprintf(os.Stderr, "[bloom] SSTable-%06d: skipped (prefix %q not present)\n", t.ID, prefix)
+			}
+			return false
+		}
+		return true
+	}
+}
+
+// prefixSuccessor returns the smallest key greater than every key starting
+// with prefix, or nil if there is none.
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			s := cloneBytes(prefix[:i+1])
+			s[i]++
+			return s
+		}
+	}
+	return nil
+}
+
+// newIterator reads v, whose reference the iterator takes over. If include
+// is set, only the tables it accepts are read.
+func (d *DB) newIterator(v *version, lower, upper []byte, seq uint64, include func(*sstable.Table) bool) (*Iterator, error) {
+	its := make([]iterator.Iterator, 0, v.numTables()+len(v.imm)+1)
+	its = append(its, v.mem.NewIterator())
+	for _, imm := range v.imm {
+		its = append(its, imm.mem.NewIterator())
+	}
+	for _, tables := range v.levels {
+		for _, tbl := range tables {
+			if include != nil && !include(tbl) {
+				continue
+			}
+			it, err := tbl.NewIterator()
+			if err != nil {
+				for _, it2 := range its {
+					_ = it2.Close()
+				}
+				d.unref(v)
+				return nil, err
+			}
+			its = append(its, it)
+		}
+	}
+	return &Iterator{
+		it:        iterator.NewMerging(its...),
+		release:   func() { d.unref(v) },
+		rangeDels: v.rangeDels(),
+		seq:       seq,
+		now:       time.Now().UnixNano(),
+		lower:     cloneBytes(lower),
+		upper:     cloneBytes(upper),
+		merge:     d.opts.MergeOperator,
+	}, nil
+}
+
+func (i *Iterator) Valid() bool { return i.valid }
+
+// Key returns the current key. It is only valid until the iterator moves.
+func (i *Iterator) Key() []byte { return i.key }
+
+// Value returns the current value. It is only valid until the iterator moves.
+func (i *Iterator) Value() []byte { return i.value }
+
+// Err returns the first I/O, corruption or merge error hit while iterating.
+func (i *Iterator) Err() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.it.Err()
+}
+
+func (i *Iterator) Close() error {
+	i.valid = false
+	err := i.it.Close()
+	if i.release != nil {
+		i.release()
+		i.release = nil
+	}
+	return err
+}
+
+// First moves to the smallest key >= lower.
+func (i *Iterator) First() {
+	i.dir = forward
+	if i.lower != nil {
+		i.it.SeekGE(i.lower)
+	} else {
+		i.it.First()
+	}
+	i.findNextUserEntry(false, nil)
+}
+
+// Last moves to the largest key < upper.
+func (i *Iterator) Last() {
+	i.dir = reverse
+	if i.upper != nil {
+		i.it.SeekGE(i.upper)
+		if i.it.Valid() {
+			i.it.Prev()
+		} else {
+			i.it.Last()
+		}
+	} else {
+		i.it.Last()
+	}
+	i.findPrevUserEntry()
+}
+
+// Seek moves to the smallest key >= key (clamped to the bounds).
+func (i *Iterator) Seek(key []byte) {
+	if i.lower != nil && bytes.Compare(key, i.lower) < 0 {
+		key = i.lower
+	}
+	i.dir = forward
+	i.it.SeekGE(key)
+	i.findNextUserEntry(false, nil)
+}
+
+func (i *Iterator) Next() {
+	if !i.valid {
+		return
+	}
+	if i.dir == reverse {
+		// Step into the entries of the current key; they get skipped below.
+		i.dir = forward
+		if i.it.Valid() {
+			i.it.Next()
+		} else {
+			i.it.First()
+		}
+	}
+	i.findNextUserEntry(true, cloneBytes(i.key))
+}
+
+func (i *Iterator) Prev() {
+	if !i.valid {
+		return
+	}
+	if i.dir == forward {
+		// Back up until we are before every entry of the current key.
+		if i.it.Valid() {
+			i.it.Prev()
+		} else {
+			i.it.Last()
+		}
+		for i.it.Valid() && bytes.Compare(i.it.Record().Key, i.key) >= 0 {
+			i.it.Prev()
+		}
+		if !i.it.Valid() {
+			i.valid = false
+			return
+		}
+		i.dir = reverse
+	}
+	i.findPrevUserEntry()
+}
+
+// findNextUserEntry stops at the first visible, non-deleted key. When
+// skipping is set, keys <= skip are passed over.
+func (i *Iterator) findNextUserEntry(skipping bool, skip []byte) {
+	for ; i.it.Valid(); i.it.Next() {
+		r := i.it.Record()
+		if r.Seq > i.seq {
+			continue
+		}
+		if skipping && bytes.Compare(r.Key, skip) <= 0 {
+			continue
+		}
+		if i.upper != nil && bytes.Compare(r.Key, i.upper) >= 0 {
+			break
+		}
+		if i.deleted(r) {
+			// Hide this key and every older version of it.
+			skipping = true
+			skip = append(skip[:0], r.Key...)
+			continue
+		}
+		i.key = append(i.key[:0], r.Key...)
+		if r.Merge {
+			i.mergeForward(r.Value)
+			return
+		}
+		i.value = append(i.value[:0], r.Value...)
+		i.valid = true
+		return
+	}
+	i.valid = false
+}
+
+// mergeForward resolves the merge operand at the current position, the
+// newest visible version of i.key, by reading on to the version the
+// operands below it end at. It leaves the internal iterator on that version
+// or past the key.
+func (i *Iterator) mergeForward(operand []byte) {
+	operands := [][]byte{cloneBytes(operand)}
+	var base []byte
+	for i.it.Next(); i.it.Valid(); i.it.Next() {
+		r := i.it.Record()
+		if !bytes.Equal(r.Key, i.key) || i.deleted(r) {
+			break
+		}
+		if !r.Merge {
+			base = r.Value
+			break
+		}
+		operands = append(operands, cloneBytes(r.Value))
+	}
+	slices.Reverse(operands)
+	i.resolve(base, operands)
+}
+
+// resolve sets the current value to operands, oldest first, applied to base
+// (nil if there is no value below them).
+func (i *Iterator) resolve(base []byte, operands [][]byte) {
+	if i.merge == nil {
+		i.err = ErrNoMergeOperator
+		i.valid = false
+		return
+	}
+	v, err := i.merge.Merge(i.key, base, operands)
+	if err != nil {
+		i.err = err
+		i.valid = false
+		return
+	}
+	i.value = append(i.value[:0], v...)
+	i.valid = true
+}
+
+// findPrevUserEntry walks backward over the versions of each key (oldest
+// first) and stops once it has passed the newest visible version of a key
+// that is not deleted.
+func (i *Iterator) findPrevUserEntry() {
+	found := false
+	var key, value []byte
+	hasValue := false     // value holds a version of key below its operands
+	var operands [][]byte // merge operands of key, oldest first
+	for ; i.it.Valid(); i.it.Prev() {
+		r := i.it.Record()
+		if i.lower != nil && bytes.Compare(r.Key, i.lower) < 0 {
+			break
+		}
+		if r.Seq > i.seq {
+			continue
+		}
+		if found && bytes.Compare(r.Key, key) < 0 {
+			break
+		}
+		if i.deleted(r) {
+			found, hasValue, operands = false, false, operands[:0]
+			continue
+		}
+		found = true
+		key = append(key[:0], r.Key...)
+		if r.Merge {
+			operands = append(operands, cloneBytes(r.Value))
+			continue
+		}
+		value = append(value[:0], r.Value...)
+		hasValue, operands = true, operands[:0]
+	}
+	if !found {
+		i.valid = false
+		i.dir = forward
+		return
+	}
+	i.key, i.value = key, value
+	i.valid = true
+	if len(operands) > 0 {
+		var base []byte
+		if hasValue {
+			base = value
+		}
+		i.resolve(base, operands)
+	}
+}
+
+// deleted reports whether r is a tombstone, an expired value or hidden by a
+// range tombstone.
+func (i *Iterator) deleted(r memtable.Record) bool {
+	return r.Tombstone || r.Expired(i.now) || r.Seq < i.rangeDels.MaxSeq(r.Key, i.seq)
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
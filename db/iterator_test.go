@@ -0,0 +1,339 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ChinmayNoob/lsm-go/merge"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+)
+
+// model is what a DB should hold after the same writes.
+type model map[string]string
+
+func (m model) keys() []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	slices.Sort(ks)
+	return ks
+}
+
+// randomWrites applies n random puts, deletes, merges and range deletes over
+// a small key space to both d and m.
+func randomWrites(t *testing.T, d *DB, m model, rng *rand.Rand, n int) {
+	t.Helper()
+	key := func() string { return fmt.Sprintf("k%02d", rng.IntN(40)) }
+	for i := 0; i < n; i++ {
+		var err error
+		switch op := rng.IntN(10); {
+		case op < 5:
+			k, v := key(), fmt.Sprintf("v%d", i)
+			err = d.Put([]byte(k), []byte(v))
+			m[k] = v
+		case op < 7:
+			k := key()
+			err = d.Delete([]byte(k))
+			delete(m, k)
+		case op < 9:
+			k, v := key(), fmt.Sprintf("m%d", i)
+			err = d.Merge([]byte(k), []byte(v))
+			if old, ok := m[k]; ok {
+				v = old + "," + v
+			}
+			m[k] = v
+		default:
+			start, end := key(), key()
+			if start > end {
+				start, end = end, start
+			}
+			err = d.DeleteRange([]byte(start), []byte(end))
+			for k := range m {
+				if k >= start && k < end {
+					delete(m, k)
+				}
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// collect walks it from First with Next, or from Last with Prev.
+func collect(t *testing.T, it *Iterator, reverse bool) []string {
+	t.Helper()
+	var got []string
+	if reverse {
+		for it.Last(); it.Valid(); it.Prev() {
+			got = append(got, string(it.Key())+"="+string(it.Value()))
+		}
+	} else {
+		for it.First(); it.Valid(); it.Next() {
+			got = append(got, string(it.Key())+"="+string(it.Value()))
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func (m model) entries(lower, upper string, reverse bool) []string {
+	var want []string
+	for _, k := range m.keys() {
+		if k >= lower && (upper == "" || k < upper) {
+			want = append(want, k+"="+m[k])
+		}
+	}
+	if reverse {
+		slices.Reverse(want)
+	}
+	return want
+}
+
+// The iterator merges the memtable, L0 and deeper levels, hiding shadowed
+// versions, point and range deletes, and folding merge operands, the same
+// way whichever direction it moves in.
+func TestIteratorMergesLevels(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	m := model{}
+	opts := DefaultOptions()
+	opts.Dir = t.TempDir()
+	opts.MergeOperator = merge.Append([]byte(","))
+	opts.MemtableMaxBytes = 256 // a table every few writes
+	opts.MaxSSTTables = 4
+	d := openTestDB(t, opts)
+	randomWrites(t, d, m, rng, 400)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave L0 tables and a memtable over what was compacted.
+	opts.MaxSSTTables = 100
+	d = openTestDB(t, opts)
+	randomWrites(t, d, m, rng, 100)
+	opts.MemtableMaxBytes = 1 << 20
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d = openTestDB(t, opts)
+	defer func() { _ = d.Close() }()
+	randomWrites(t, d, m, rng, 50)
+	d.mu.Lock()
+	levels := d.current.levels
+	deeper := 0
+	for _, tables := range levels[1:] {
+		deeper += len(tables)
+	}
+	d.mu.Unlock()
+	if len(levels[0]) == 0 || deeper == 0 {
+		t.Fatalf("want tables in L0 and below it, have %d and %d", len(levels[0]), deeper)
+	}
+
+	bounds := []struct{ lower, upper string }{
+		{"", ""},
+		{"k10", "k30"},
+		{"k05", "k06"},
+		{"k99", ""},
+	}
+	for _, b := range bounds {
+		var lower, upper []byte
+		if b.lower != "" {
+			lower = []byte(b.lower)
+		}
+		if b.upper != "" {
+			upper = []byte(b.upper)
+		}
+		it, err := d.NewIterator(lower, upper)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, reverse := range []bool{false, true} {
+			got, want := collect(t, it, reverse), m.entries(b.lower, b.upper, reverse)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("[%q, %q) reverse=%v:\n got %q\nwant %q", b.lower, b.upper, reverse, got, want)
+			}
+		}
+		_ = it.Close()
+	}
+}
+
+// Switching direction mid-scan lands on the neighbouring live key, not on
+// an older version or a deleted key next to it.
+func TestIteratorSwitchDirection(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	m := model{}
+	opts := DefaultOptions()
+	opts.MergeOperator = merge.Append([]byte(","))
+	opts.MemtableMaxBytes = 256
+	d := openTestDB(t, opts)
+	defer func() { _ = d.Close() }()
+	randomWrites(t, d, m, rng, 300)
+
+	keys := m.keys()
+	it, err := d.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = it.Close() }()
+	for i := 0; i < 40; i++ {
+		target := fmt.Sprintf("k%02d", i)
+		j, _ := slices.BinarySearch(keys, target)
+		it.Seek([]byte(target))
+		if j == len(keys) {
+			if it.Valid() {
+				t.Errorf("Seek(%s) = %s, want end", target, it.Key())
+			}
+			continue
+		}
+		if !it.Valid() || string(it.Key()) != keys[j] || string(it.Value()) != m[keys[j]] {
+			t.Fatalf("Seek(%s) = %q=%q, want %s", target, it.Key(), it.Value(), keys[j])
+		}
+		it.Prev()
+		if j == 0 {
+			if it.Valid() {
+				t.Errorf("Prev after Seek(%s) = %s, want end", target, it.Key())
+			}
+			continue
+		}
+		if !it.Valid() || string(it.Key()) != keys[j-1] || string(it.Value()) != m[keys[j-1]] {
+			t.Fatalf("Prev after Seek(%s) = %q=%q, want %s", target, it.Key(), it.Value(), keys[j-1])
+		}
+		it.Next()
+		if !it.Valid() || string(it.Key()) != keys[j] || string(it.Value()) != m[keys[j]] {
+			t.Fatalf("Prev, Next after Seek(%s) = %q=%q, want %s", target, it.Key(), it.Value(), keys[j])
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrefixIterator(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PrefixExtractor = sstable.SeparatorPrefix(':', 1)
+	opts.MemtableMaxBytes = 1 // every write is flushed to a table
+	opts.MaxSSTTables = 100
+	d := openTestDB(t, opts)
+	defer func() { _ = d.Close() }()
+	write := func(keys ...string) {
+		t.Helper()
+		var b WriteBatch
+		for _, k := range keys {
+			b.Put([]byte(k), []byte("v"+k))
+		}
+		if err := d.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("user:1")
+	write("user:2", "user:3")
+	write("users")
+	write("a:1", "z:1") // spans "user:" but doesn't hold it
+	write("\xff:1")
+	if err := d.Delete([]byte("user:3")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"user:", []string{"user:1=vuser:1", "user:2=vuser:2"}},
+		// Not a whole prefix, so no table is skipped, but the scan is the same.
+		{"user", []string{"user:1=vuser:1", "user:2=vuser:2", "users=vusers"}},
+		{"order:", nil},
+		{"\xff", []string{"\xff:1=v\xff:1"}},
+	}
+	for _, tt := range tests {
+		it, err := d.NewPrefixIterator([]byte(tt.prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, reverse := range []bool{false, true} {
+			want := slices.Clone(tt.want)
+			if reverse {
+				slices.Reverse(want)
+			}
+			if got := collect(t, it, reverse); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("NewPrefixIterator(%q) reverse=%v = %q, want %q", tt.prefix, reverse, got, want)
+			}
+		}
+		_ = it.Close()
+	}
+
+	// The prefix filters rule out the table holding a:1 and z:1.
+	d.mu.Lock()
+	tables := d.current.levels[0]
+	d.mu.Unlock()
+	prefix := []byte("user:")
+	read := d.prefixFilter(prefix, prefixSuccessor(prefix))
+	for _, tbl := range tables {
+		holds := bytes.HasPrefix(tbl.Smallest(), prefix) || bytes.HasPrefix(tbl.Largest(), prefix)
+		if read(tbl) != holds {
+			t.Errorf("prefix %q: table [%q, %q] read = %v, want %v", prefix, tbl.Smallest(), tbl.Largest(), read(tbl), holds)
+		}
+	}
+}
+
+// Merge operands spread over a table and the memtable are applied to the
+// value under them, oldest first, by Get and by the iterator either way.
+func TestMergeAcrossLevels(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Dir = t.TempDir()
+	opts.MergeOperator = merge.Append([]byte(","))
+	opts.MemtableMaxBytes = 1 // every write is flushed to a table
+	d := openTestDB(t, opts)
+	writes := []func() error{
+		func() error { return d.Put([]byte("a"), []byte("1")) },
+		func() error { return d.Merge([]byte("a"), []byte("2")) },
+		func() error { return d.Put([]byte("b"), []byte("old")) },
+		func() error { return d.Delete([]byte("b")) },
+		func() error { return d.Merge([]byte("b"), []byte("1")) },
+		func() error { return d.Merge([]byte("c"), []byte("1")) },
+	}
+	for _, w := range writes {
+		if err := w(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	opts.MemtableMaxBytes = 1 << 20
+	d = openTestDB(t, opts)
+	defer func() { _ = d.Close() }()
+	for _, k := range []string{"a", "b", "c"} {
+		if err := d.Merge([]byte(k), []byte("3")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"a=1,2,3", "b=1,3", "c=1,3"}
+	for _, kv := range want {
+		k, w, _ := strings.Cut(kv, "=")
+		if v, _, err := d.Get([]byte(k)); err != nil || string(v) != w {
+			t.Errorf("Get(%s) = %q, %v; want %q", k, v, err, w)
+		}
+	}
+	it, err := d.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = it.Close() }()
+	for _, reverse := range []bool{false, true} {
+		w := slices.Clone(want)
+		if reverse {
+			slices.Reverse(w)
+		}
+		if got := collect(t, it, reverse); fmt.Sprint(got) != fmt.Sprint(w) {
+			t.Errorf("reverse=%v: %q, want %q", reverse, got, w)
+		}
+	}
+}
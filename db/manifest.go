@@ -0,0 +1,341 @@
+package db
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ChinmayNoob/lsm-go/compaction"
+	"github.com/ChinmayNoob/lsm-go/manifest"
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+	"github.com/ChinmayNoob/lsm-go/wal"
+)
+
+// recoverLocked rebuilds the DB from the manifest CURRENT points to and the
+// WALs it still references, then starts a fresh manifest and deletes every
+// file the recovered state doesn't reference.
+func (d *DB) recoverLocked() error {
+	st, err := manifest.Load(d.opts.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		st, err = d.legacyState()
+	}
+	if err != nil {
+		return err
+	}
+	d.nextFile = st.NextFileNum
+	d.seq = st.LastSeq + 1
+	v := &version{mem: memtable.New()}
+
+	for _, f := range st.Files {
+		if f.Level < 0 || f.Level >= compaction.NumLevels {
+			return fmt.Errorf("%w: table %d in level %d", manifest.ErrCorrupt, f.Num, f.Level)
+		}
+		path := filepath.Join(d.sstDir, sstable.FormatFilename(f.Num))
+		t, err := sstable.OpenIngested(path, f.Num, f.GlobalSeq, d.readerOptions())
+		if err != nil {
+			return err
+		}
+		v.levels[f.Level] = append(v.levels[f.Level], t)
+		// Tables written before LastSeq was recorded may hold newer
+		// sequence numbers.
+		if t.MaxSeq() >= d.seq {
+			d.seq = t.MaxSeq() + 1
+		}
+	}
+	slices.SortFunc(v.levels[0], func(a, b *sstable.Table) int { return cmp.Compare(a.ID, b.ID) })
+	for level := 1; level < compaction.NumLevels; level++ {
+		compaction.SortLevel(v.levels[level])
+	}
+
+	// Every log from LogNumber on holds writes that aren't in a table yet.
+	logs, err := d.listLogs(st.LogNumber)
+	if err != nil {
+		return err
+	}
+	for i, num := range logs {
+		if err := d.replayLog(num, v.mem, i == len(logs)-1); err != nil {
+			return err
+		}
+		if num >= d.nextFile {
+			d.nextFile = num + 1
+		}
+	}
+
+	// Keep appending to the newest log; the older ones stay referenced until
+	// the next flush.
+	logNumber := st.LogNumber
+	if len(logs) > 0 {
+		d.logNum = logs[len(logs)-1]
+		logNumber = logs[0]
+	} else {
+		d.logNum = d.newFileNumLocked()
+		logNumber = d.logNum
+	}
+	d.memLog = logNumber
+	d.installLocked(v)
+	w, err := wal.Open(d.logPath(d.logNum), d.opts.SyncOnWrite)
+	if err != nil {
+		return err
+	}
+	d.w = w
+
+	manNum := d.newFileNumLocked()
+	d.man, err = manifest.Create(d.opts.Dir, manNum, manifest.State{
+		LogNumber:   logNumber,
+		NextFileNum: d.nextFile,
+		LastSeq:     d.seq - 1,
+		Files:       d.liveFilesLocked(),
+	})
+	if err != nil {
+		return err
+	}
+	return d.removeObsoleteFilesLocked()
+}
+
+// legacyState describes a directory written before the manifest log: its
+// table set comes from the snapshot manifest if there is one, and otherwise
+// every table file is put in L0, where compaction sorts them into levels.
+// The old wal.log (and any wal.log.old-N a crashed flush left behind) are
+// renamed to numbered logs so they get replayed like any other; without a
+// snapshot manifest they are renumbered to follow the tables (see
+// scanTables).
+func (d *DB) legacyState() (manifest.State, error) {
+	st, err := manifest.LoadLegacy(d.opts.Dir)
+	renumber := false
+	if errors.Is(err, os.ErrNotExist) {
+		st, err = scanTables(d.sstDir)
+		renumber = true
+	}
+	if err != nil {
+		return manifest.State{}, err
+	}
+
+	ents, err := os.ReadDir(d.opts.Dir)
+	if err != nil {
+		return manifest.State{}, err
+	}
+	var old []string
+	for _, e := range ents {
+		if strings.HasPrefix(e.Name(), "wal.log.old-") {
+			old = append(old, e.Name())
+		}
+	}
+	oldNum := func(name string) uint64 {
+		n, _ := strconv.ParseUint(strings.TrimPrefix(name, "wal.log.old-"), 10, 64)
+		return n
+	}
+	slices.SortFunc(old, func(a, b string) int { return cmp.Compare(oldNum(a), oldNum(b)) })
+	if _, err := os.Stat(filepath.Join(d.opts.Dir, "wal.log")); err == nil {
+		old = append(old, "wal.log")
+	}
+	for _, name := range old {
+		if st.NextFileNum == 0 {
+			st.NextFileNum = 1
+		}
+		num := st.NextFileNum
+		st.NextFileNum++
+		src := filepath.Join(d.opts.Dir, name)
+		if renumber {
+			if st.LastSeq, err = renumberLog(src, d.logPath(num), st.LastSeq); err != nil {
+				return manifest.State{}, err
+			}
+			continue
+		}
+		if err := os.Rename(src, d.logPath(num)); err != nil {
+			return manifest.State{}, err
+		}
+	}
+	return st, nil
+}
+
+// scanTables lists the table files in dir as L0 tables. The DB that wrote
+// them started its sequence numbers over on every open, so the ones in the
+// files can't tell which table is newer. Each table reads at a sequence
+// number of its own instead, in file number order, and LastSeq is the
+// newest.
+func scanTables(dir string) (manifest.State, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return manifest.State{}, err
+	}
+	st := manifest.State{NextFileNum: 1}
+	for _, e := range ents {
+		num, ok := sstable.ParseFilename(e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		st.Files = append(st.Files, manifest.FileMeta{Level: 0, Num: num})
+		if num >= st.NextFileNum {
+			st.NextFileNum = num + 1
+		}
+	}
+	slices.SortFunc(st.Files, func(a, b manifest.FileMeta) int { return cmp.Compare(a.Num, b.Num) })
+	for i := range st.Files {
+		st.Files[i].GlobalSeq = uint64(i + 1)
+	}
+	st.LastSeq = uint64(len(st.Files))
+	return st, nil
+}
+
+// renumberLog copies the records of the log at src to a new log at dst,
+// numbering them from last+1 on, and removes src. It returns the last
+// number used. A torn tail is left out.
+func renumberLog(src, dst string, last uint64) (uint64, error) {
+	tmp := dst + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	w, err := wal.Open(tmp, true)
+	if err != nil {
+		return 0, err
+	}
+	_, err = wal.Replay(src, func(r wal.Record) error {
+		last++
+		return w.AppendBatch(last, []wal.Record{r})
+	})
+	var cerr *wal.CorruptionError
+	if errors.As(err, &cerr) && cerr.Truncated {
+		err = nil
+	}
+	if werr := w.Close(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return 0, err
+	}
+	return last, os.Remove(src)
+}
+
+// listLogs returns the numbers of the WAL files >= min, ascending.
+func (d *DB) listLogs(min uint64) ([]uint64, error) {
+	ents, err := os.ReadDir(d.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var nums []uint64
+	for _, e := range ents {
+		if num, ok := wal.ParseFilename(e.Name()); ok && num >= min {
+			nums = append(nums, num)
+		}
+	}
+	slices.Sort(nums)
+	return nums, nil
+}
+
+// replayLog applies the records of WAL num to mem. Only the newest log can
+// have been torn by a crash; older ones were complete when the next one was
+// started.
+func (d *DB) replayLog(num uint64, mem *memtable.Memtable, newest bool) error {
+	path := d.logPath(num)
+	maxSeq, err := wal.Replay(path, func(r wal.Record) error {
+		applyOp(mem, r, r.Seq)
+		d.memBytes += approxRecordBytes(r.Key, r.Value)
+		return nil
+	})
+	var cerr *wal.CorruptionError
+	if errors.As(err, &cerr) && cerr.Truncated && newest {
+		// A crash tore the last write. Everything before it was replayed;
+		// cut the log there so new records don't land after the garbage.
+		if d.opts.Verbose {
+			fmt.Fprintf(os.Stderr, "[wal] %s: %v, truncating\n", wal.FormatFilename(num), err)
+		}
+		if err := os.Truncate(path, cerr.Offset); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if maxSeq >= d.seq {
+		d.seq = maxSeq + 1
+	}
+	return nil
+}
+
+func (d *DB) logPath(num uint64) string {
+	return filepath.Join(d.opts.Dir, wal.FormatFilename(num))
+}
+
+func (d *DB) liveFilesLocked() []manifest.FileMeta {
+	var files []manifest.FileMeta
+	for level, tables := range d.current.levels {
+		for _, t := range tables {
+			files = append(files, manifest.FileMeta{Level: level, Num: t.ID, GlobalSeq: t.GlobalSeq()})
+		}
+	}
+	return files
+}
+
+// logAndApplyLocked commits e to the manifest, stamping it with the current
+// file number and sequence counters. Callers update d.levels only after it
+// succeeds.
+func (d *DB) logAndApplyLocked(e *manifest.VersionEdit) error {
+	next, last := d.nextFile, d.seq-1
+	e.NextFileNum = &next
+	e.LastSeq = &last
+	return d.man.Apply(e)
+}
+
+// removeObsoleteFilesLocked deletes tables neither the manifest nor a live
+// version lists (save those still being written), logs older than the
+// manifest's LogNumber, old manifests and leftover temporary files.
+func (d *DB) removeObsoleteFilesLocked() error {
+	st := d.man.State()
+	live := d.liveTablesLocked()
+	for _, f := range st.Files {
+		live[f.Num] = true
+	}
+
+	ents, err := os.ReadDir(d.sstDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range ents {
+		name, tmp := strings.CutSuffix(e.Name(), ".tmp")
+		num, ok := sstable.ParseFilename(name)
+		if ok && d.pending[num] {
+			continue
+		}
+		if (ok && !live[num]) || tmp {
+			if d.opts.Verbose {
+				fmt.Fprintf(os.Stderr, "[gc] removing %s\n", e.Name())
+			}
+			_ = os.Remove(filepath.Join(d.sstDir, e.Name()))
+		}
+	}
+
+	ents, err = os.ReadDir(d.opts.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range ents {
+		name := e.Name()
+		obsolete := name == manifest.LegacyFilename ||
+			name == manifest.LegacyFilename+".tmp" || name == manifest.CurrentFilename+".tmp"
+		if base, ok := strings.CutSuffix(name, ".tmp"); ok {
+			// A log renumberLog didn't finish.
+			if _, ok := wal.ParseFilename(base); ok {
+				obsolete = true
+			}
+		}
+		if num, ok := wal.ParseFilename(name); ok && num < st.LogNumber && d.logRefs[num] == 0 {
+			obsolete = true
+		}
+		if num, ok := manifest.ParseFilename(name); ok && num != d.man.Num() {
+			obsolete = true
+		}
+		if obsolete {
+			_ = os.Remove(filepath.Join(d.opts.Dir, name))
+		}
+	}
+	return nil
+}
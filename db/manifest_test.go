@@ -0,0 +1,179 @@
+package db
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChinmayNoob/lsm-go/manifest"
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+	"github.com/ChinmayNoob/lsm-go/wal"
+)
+
+// writeBaselineTable writes recs as a v1 table, the format of the DB before
+// block tables and the manifest:
+// [u32 keyLen][key][u8 tomb][u32 valLen][val][u64 seq] per entry, then the
+// footer [u64 indexOffset][u32 magic][u16 version].
+func writeBaselineTable(t *testing.T, dir string, num uint64, recs ...memtable.Record) {
+	t.Helper()
+	var b []byte
+	for _, r := range recs {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(r.Key)))
+		b = append(b, r.Key...)
+		tomb := byte(0)
+		if r.Tombstone {
+			tomb = 1
+		}
+		b = append(b, tomb)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(r.Value)))
+		b = append(b, r.Value...)
+		b = binary.LittleEndian.AppendUint64(b, r.Seq)
+	}
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(b)))
+	b = binary.LittleEndian.AppendUint32(b, 0x4c534d31)
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	if err := os.WriteFile(filepath.Join(dir, sstable.FormatFilename(num)), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeBaselineLog writes recs to a v1 wal.log, one unchecksummed frame each:
+// [u32 len][u8 op][u64 seq][u32 keyLen][u32 valLen][key][val].
+func writeBaselineLog(t *testing.T, dir string, recs ...wal.Record) {
+	t.Helper()
+	var b []byte
+	for _, r := range recs {
+		b = binary.LittleEndian.AppendUint32(b, uint32(1+8+4+4+len(r.Key)+len(r.Value)))
+		b = append(b, byte(r.Op))
+		b = binary.LittleEndian.AppendUint64(b, r.Seq)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(r.Key)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(r.Value)))
+		b = append(b, r.Key...)
+		b = append(b, r.Value...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "wal.log"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A baseline directory has no manifest, and every run of the baseline
+// started its sequence numbers over, so its tables and log all say seq 1.
+// Upgrading it must keep them in the order they were written, through
+// compaction too.
+func TestOpenBaselineDirectory(t *testing.T) {
+	dir := t.TempDir()
+	sstDir := filepath.Join(dir, "sstables")
+	if err := os.MkdirAll(sstDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// put k1..k5 and del k2, each run flushing at once, then a run that
+	// left put k4 and del k5 in the log.
+	for i, k := range []string{"k1", "k2", "k3", "k4", "k5"} {
+		writeBaselineTable(t, sstDir, uint64(i+1), memtable.Record{Key: []byte(k), Value: []byte("v" + k[1:]), Seq: 1})
+	}
+	writeBaselineTable(t, sstDir, 6, memtable.Record{Key: []byte("k2"), Tombstone: true, Seq: 1})
+	writeBaselineLog(t, dir,
+		wal.Record{Op: wal.OpPut, Key: []byte("k4"), Value: []byte("v4b"), Seq: 1},
+		wal.Record{Op: wal.OpDelete, Key: []byte("k5"), Seq: 2},
+	)
+
+	opts := DefaultOptions()
+	opts.Dir = dir
+	opts.SyncOnWrite = false
+	opts.MemtableMaxBytes = 1
+	opts.MaxSSTTables = 1
+	d, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flushes the replayed log along with k7; L0 is then compacted.
+	if err := d.Put([]byte("k7"), []byte("v7")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := d.CompactionStats(); st.TombstonesDropped == 0 {
+		t.Fatalf("L0 wasn't compacted: %+v", st)
+	}
+
+	d, err = Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+	want := map[string]string{"k1": "v1", "k3": "v3", "k4": "v4b", "k7": "v7"}
+	for _, k := range []string{"k1", "k2", "k3", "k4", "k5", "k7"} {
+		v, ok, err := d.Get([]byte(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, live := want[k]; ok != live || string(v) != w {
+			t.Errorf("Get(%s) = %q, %v; want %q, %v", k, v, ok, w, live)
+		}
+	}
+	it, err := d.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = it.Close() }()
+	n := 0
+	for it.First(); it.Valid(); it.Next() {
+		if w := want[string(it.Key())]; string(it.Value()) != w {
+			t.Errorf("iterator: %s = %q, want %q", it.Key(), it.Value(), w)
+		}
+		n++
+	}
+	if n != len(want) {
+		t.Errorf("iterator saw %d keys, want %d", n, len(want))
+	}
+}
+
+// A crash while committing an edit leaves part of it at the end of the
+// manifest; reopening ignores it and keeps the table set before it.
+func TestOpenTornManifest(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Dir = t.TempDir()
+	opts.MemtableMaxBytes = 1 // every write is flushed to a table
+	d := openTestDB(t, opts)
+	for _, k := range []string{"a", "b", "c"} {
+		if err := d.Put([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cur, err := os.ReadFile(filepath.Join(opts.Dir, manifest.CurrentFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(opts.Dir, strings.TrimSpace(string(cur))), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header of a 100-byte edit, and only part of its body.
+	torn := binary.LittleEndian.AppendUint32(nil, 100)
+	torn = append(torn, 1, 2, 3, 4, 5, 6, 7)
+	if _, err := f.Write(torn); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = openTestDB(t, opts)
+	defer func() { _ = d.Close() }()
+	for _, k := range []string{"a", "b", "c"} {
+		if v, _, err := d.Get([]byte(k)); err != nil || string(v) != "v"+k {
+			t.Errorf("Get(%s) = %q, %v; want %q", k, v, err, "v"+k)
+		}
+	}
+	if err := d.Put([]byte("d"), []byte("vd")); err != nil {
+		t.Fatal(err)
+	}
+}
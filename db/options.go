@@ -1,19 +1,41 @@
 package db
 
+import (
+	"github.com/ChinmayNoob/lsm-go/compaction"
+	"github.com/ChinmayNoob/lsm-go/merge"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+)
+
 type Options struct {
-	Dir string //base dir
-	SyncOnWrite bool //fsyncs the wal after each record
-	MemtableMaxBytes int //triggers flush when it exceeds
-	MaxSSTTables int // triggers compaction
-	Verbose bool //bloom filter hit/miss
+	Dir                   string                  //base dir
+	SyncOnWrite           bool                    //fsyncs the wal after each record
+	MemtableMaxBytes      int                     //triggers flush when it exceeds
+	MaxImmutableMemtables int                     //full memtables queued for flush before writes wait
+	MaxSSTTables          int                     // L0 tables before compaction into L1, 0 disables compaction
+	BaseLevelBytes        int64                   //size target of L1
+	LevelMultiplier       int                     //size ratio between consecutive levels
+	TargetFileSize        int64                   //compaction output size
+	Compression           sstable.Compression     //codec for new SSTable blocks; existing tables keep theirs
+	PrefixExtractor       sstable.PrefixExtractor //prefixes added to new SSTables' Bloom filters for NewPrefixIterator, nil for none
+	MergeOperator         merge.Operator          //resolves DB.Merge operands, nil disables Merge
+	CompactionFilter      compaction.Filter       //drops or rewrites the newest value of keys compaction rewrites, nil for none
+	BlockCacheBytes       int64                   //LRU cache for SSTable blocks, 0 disables
+	MaxOpenFiles          int                     //SSTable files kept open between reads, 0 opens per read
+	Verbose               bool                    //bloom filter hit/miss
 }
 
 func DefaultOptions() Options {
 	return Options{
-		Dir: "",
-		SyncOnWrite: true,
-		MemtableMaxBytes: 0,
-		MaxSSTTables: 0,
+		Dir:                   "",
+		SyncOnWrite:           true,
+		MemtableMaxBytes:      0,
+		MaxImmutableMemtables: 2,
+		MaxSSTTables:          0,
+		BaseLevelBytes:        10 << 20,
+		LevelMultiplier:       10,
+		TargetFileSize:        2 << 20,
+		Compression:           sstable.LZCompression,
+		BlockCacheBytes:       8 << 20,
+		MaxOpenFiles:          500,
 	}
 }
-
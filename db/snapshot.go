@@ -0,0 +1,97 @@
+package db
+
+import "container/list"
+
+// Snapshot is a read-only view of the DB as of the moment it was taken:
+// reads through it only see records with Seq <= Seq(). Writers keep going
+// meanwhile; compactions retain the versions a live snapshot can still read,
+// so Release it once done.
+type Snapshot struct {
+	d    *DB
+	seq  uint64
+	elem *list.Element // nil once released
+}
+
+// NewSnapshot pins the current sequence number.
+func (d *DB) NewSnapshot() (*Snapshot, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+	s := &Snapshot{d: d, seq: d.seq - 1}
+	s.elem = d.snapshots.PushBack(s)
+	return s, nil
+}
+
+// Seq returns the sequence number the snapshot is bound to.
+func (s *Snapshot) Seq() uint64 { return s.seq }
+
+// Get is like DB.Get but only sees writes made before the snapshot.
+func (s *Snapshot) Get(key []byte) ([]byte, bool, error) {
+	if len(key) == 0 {
+		return nil, false, ErrEmptyKey
+	}
+	d := s.d
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, false, ErrClosed
+	}
+	if s.elem == nil {
+		d.mu.Unlock()
+		return nil, false, ErrSnapshotReleased
+	}
+	v := d.refLocked()
+	d.mu.Unlock()
+	defer d.unref(v)
+	return d.get(v, key, s.seq)
+}
+
+// NewIterator is like DB.NewIterator but only sees writes made before the
+// snapshot. The iterator stays usable after the snapshot is released.
+func (s *Snapshot) NewIterator(lower, upper []byte) (*Iterator, error) {
+	d := s.d
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if s.elem == nil {
+		d.mu.Unlock()
+		return nil, ErrSnapshotReleased
+	}
+	v := d.refLocked()
+	d.mu.Unlock()
+	return d.newIterator(v, lower, upper, s.seq, nil)
+}
+
+// Release lets compactions drop the versions only this snapshot could see.
+// It is safe to call more than once.
+func (s *Snapshot) Release() {
+	d := s.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s.elem != nil {
+		d.snapshots.Remove(s.elem)
+		s.elem = nil
+	}
+}
+
+// smallestSnapshotLocked returns the oldest sequence number any reader may
+// still ask for.
+func (d *DB) smallestSnapshotLocked() uint64 {
+	if front := d.snapshots.Front(); front != nil {
+		return front.Value.(*Snapshot).seq
+	}
+	return d.seq - 1
+}
+
+// newestSnapshotLocked returns the sequence number of the newest live
+// snapshot, or 0 if there are none.
+func (d *DB) newestSnapshotLocked() uint64 {
+	if back := d.snapshots.Back(); back != nil {
+		return back.Value.(*Snapshot).seq
+	}
+	return 0
+}
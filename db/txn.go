@@ -0,0 +1,186 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ChinmayNoob/lsm-go/sstable"
+	"github.com/ChinmayNoob/lsm-go/wal"
+)
+
+var (
+	ErrConflict = errors.New("transaction conflict: a key it read was written since it began")
+	ErrTxnDone  = errors.New("transaction already committed or rolled back")
+)
+
+// Txn is an optimistic transaction. It reads from a snapshot taken when it
+// began, plus its own writes, and buffers its writes until Commit. Nothing
+// is locked meanwhile: Commit checks that no key the transaction read was
+// written since it began, and only then commits the writes, atomically, as
+// one WAL record.
+//
+// A Txn must not be used from several goroutines at once. Commit or Rollback
+// it once done, which releases its snapshot.
+type Txn struct {
+	d      *DB
+	snap   *Snapshot
+	reads  map[string]struct{} // keys read from the snapshot
+	batch  WriteBatch
+	writes map[string]int // index of each key's last op in batch
+	done   bool
+}
+
+// BeginTxn starts a transaction reading the DB as of now.
+func (d *DB) BeginTxn() (*Txn, error) {
+	snap, err := d.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Txn{
+		d:      d,
+		snap:   snap,
+		reads:  make(map[string]struct{}),
+		writes: make(map[string]int),
+	}, nil
+}
+
+// Get returns the value of key as the transaction sees it: its own latest
+// write of key if there is one, the snapshot's value otherwise. Only keys
+// read from the snapshot are checked for conflicts at Commit.
+func (t *Txn) Get(key []byte) ([]byte, bool, error) {
+	if t.done {
+		return nil, false, ErrTxnDone
+	}
+	if len(key) == 0 {
+		return nil, false, ErrEmptyKey
+	}
+	if i, ok := t.writes[string(key)]; ok {
+		op := t.batch.ops[i]
+		if op.Op == wal.OpDelete {
+			return nil, false, nil
+		}
+		return cloneBytes(op.Value), true, nil
+	}
+	t.reads[string(key)] = struct{}{}
+	return t.snap.Get(key)
+}
+
+// Put buffers a write of key until Commit.
+func (t *Txn) Put(key, value []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	t.batch.Put(key, value)
+	t.writes[string(key)] = t.batch.Count() - 1
+	return nil
+}
+
+// Delete buffers a delete of key until Commit.
+func (t *Txn) Delete(key []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	t.batch.Delete(key)
+	t.writes[string(key)] = t.batch.Count() - 1
+	return nil
+}
+
+// Commit applies the transaction's writes atomically, or fails with
+// ErrConflict, applying none of them, if a key it read has been written
+// (or deleted) since it began. Either way the transaction is over.
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	defer t.snap.Release()
+	if t.batch.Count() == 0 {
+		return nil
+	}
+	d := t.d
+	return d.write(&t.batch, func() error { return d.checkConflictsLocked(t.reads, t.snap.seq) })
+}
+
+// Rollback drops the transaction's writes. Rolling back a transaction that
+// is already over does nothing.
+func (t *Txn) Rollback() {
+	if t.done {
+		return
+	}
+	t.done = true
+	t.snap.Release()
+}
+
+// checkConflictsLocked returns ErrConflict if one of keys has a version
+// newer than seq. It runs at the head of the write queue, so no write can
+// come in until the transaction is committed; d.mu is released while it
+// reads.
+func (d *DB) checkConflictsLocked(keys map[string]struct{}, seq uint64) error {
+	v := d.refLocked()
+	d.mu.Unlock()
+	err := func() error {
+		for k := range keys {
+			changed, err := v.changedSince([]byte(k), seq)
+			if err != nil {
+				return err
+			}
+			if changed {
+				if d.opts.Verbose {
+					fmt.Fprintf(os.Stderr, "[txn] conflict on %q\n", k)
+				}
+				return fmt.Errorf("%w: %q", ErrConflict, k)
+			}
+		}
+		return nil
+	}()
+	d.mu.Lock()
+	d.unrefLocked(v)
+	return err
+}
+
+// changedSince reports whether v holds a version of key, or a range
+// tombstone covering it, newer than seq. The transaction's snapshot keeps
+// compactions from dropping any such version.
+func (v *version) changedSince(key []byte, seq uint64) (bool, error) {
+	if v.rangeDelSeq(key, ^uint64(0)) > seq {
+		return true, nil
+	}
+	if r, ok := v.mem.Get(key); ok && r.Seq > seq {
+		return true, nil
+	}
+	for _, imm := range v.imm {
+		if r, ok := imm.mem.Get(key); ok && r.Seq > seq {
+			return true, nil
+		}
+	}
+	for level, tables := range v.levels {
+		if level > 0 {
+			t := findTable(tables, key)
+			if t == nil {
+				continue
+			}
+			tables = []*sstable.Table{t}
+		}
+		for _, t := range tables {
+			// Tables written before the snapshot can't hold newer writes.
+			if t.MaxSeq() <= seq || !t.MaybeContains(key) {
+				continue
+			}
+			r, ok, err := t.Get(key)
+			if err != nil {
+				return false, err
+			}
+			if ok && r.Seq > seq {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTxnConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		read     string          // the key the transaction reads
+		other    func(*DB) error // a write committed while it runs
+		conflict bool
+	}{
+		{"put of a read key", "a", func(d *DB) error { return d.Put([]byte("a"), []byte("2")) }, true},
+		{"delete of a read key", "a", func(d *DB) error { return d.Delete([]byte("a")) }, true},
+		{"range delete over a read key", "a", func(d *DB) error { return d.DeleteRange([]byte("0"), []byte("b")) }, true},
+		{"put of a key read as missing", "m", func(d *DB) error { return d.Put([]byte("m"), []byte("2")) }, true},
+		{"put of an unread key", "a", func(d *DB) error { return d.Put([]byte("b"), []byte("2")) }, false},
+		{"range delete next to a read key", "a", func(d *DB) error { return d.DeleteRange([]byte("b"), []byte("z")) }, false},
+	}
+	for _, flush := range []bool{false, true} {
+		for _, tt := range tests {
+			name := tt.name
+			if flush {
+				name += " from a table"
+			}
+			t.Run(name, func(t *testing.T) {
+				opts := DefaultOptions()
+				if flush { // let Commit find the write in a table
+					opts.MemtableMaxBytes = 1 // every write is flushed to a table
+				}
+				d := openTestDB(t, opts)
+				defer func() { _ = d.Close() }()
+				for _, k := range []string{"a", "b"} {
+					if err := d.Put([]byte(k), []byte("1")); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				txn, err := d.BeginTxn()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, _, err := txn.Get([]byte(tt.read)); err != nil {
+					t.Fatal(err)
+				}
+				if err := txn.Put([]byte("c"), []byte("txn")); err != nil {
+					t.Fatal(err)
+				}
+				if err := tt.other(d); err != nil {
+					t.Fatal(err)
+				}
+				if flush { // let Commit find the write in a table
+					d.mu.Lock()
+					for len(d.current.imm) > 0 && d.bgErr == nil {
+						d.bgCond.Wait()
+					}
+					d.mu.Unlock()
+				}
+				err = txn.Commit()
+				if tt.conflict != errors.Is(err, ErrConflict) {
+					t.Fatalf("Commit = %v, want conflict %v", err, tt.conflict)
+				}
+				if !tt.conflict && err != nil {
+					t.Fatal(err)
+				}
+				_, ok, err := d.Get([]byte("c"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok == tt.conflict {
+					t.Errorf("after Commit, c written = %v, want %v", ok, !tt.conflict)
+				}
+			})
+		}
+	}
+}
+
+// A transaction reads its own writes over its snapshot, and writes from
+// after it began stay invisible.
+func TestTxnReads(t *testing.T) {
+	d := openTestDB(t, DefaultOptions())
+	defer func() { _ = d.Close() }()
+	for _, k := range []string{"a", "b", "c"} {
+		if err := d.Put([]byte(k), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	txn, err := d.BeginTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Rollback()
+	if err := d.Put([]byte("c"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put([]byte("a"), []byte("txn")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{"a", "txn", true},
+		{"b", "", false},
+		{"c", "1", true},
+		{"d", "", false},
+	} {
+		v, ok, err := txn.Get([]byte(tt.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok || string(v) != tt.value {
+			t.Errorf("Get(%s) = %q, %v; want %q, %v", tt.key, v, ok, tt.value, tt.ok)
+		}
+	}
+	// Nothing is written before Commit.
+	if v, _, _ := d.Get([]byte("a")); string(v) != "1" {
+		t.Errorf("DB Get(a) = %q before Commit, want 1", v)
+	}
+}
+
+func TestTxnDone(t *testing.T) {
+	d := openTestDB(t, DefaultOptions())
+	defer func() { _ = d.Close() }()
+	for _, end := range []string{"commit", "rollback"} {
+		txn, err := d.BeginTxn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := txn.Put([]byte(end), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		if end == "commit" {
+			if err := txn.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			txn.Rollback()
+		}
+		if _, ok, _ := d.Get([]byte(end)); ok != (end == "commit") {
+			t.Errorf("after %s, written = %v", end, ok)
+		}
+		if _, _, err := txn.Get([]byte("x")); !errors.Is(err, ErrTxnDone) {
+			t.Errorf("Get after %s = %v, want ErrTxnDone", end, err)
+		}
+		if err := txn.Put([]byte("x"), nil); !errors.Is(err, ErrTxnDone) {
+			t.Errorf("Put after %s = %v, want ErrTxnDone", end, err)
+		}
+		if err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+			t.Errorf("Commit after %s = %v, want ErrTxnDone", end, err)
+		}
+		txn.Rollback() // does nothing
+	}
+	d.mu.Lock()
+	n := d.snapshots.Len()
+	d.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d snapshots still held", n)
+	}
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"os"
+	"slices"
+
+	"github.com/ChinmayNoob/lsm-go/compaction"
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/rangedel"
+	"github.com/ChinmayNoob/lsm-go/sstable"
+)
+
+// version is what a read sees: the memtable, the frozen memtables and the
+// tables of every level. A version never changes once installed; rotations,
+// flushes and compactions install a new one. Readers reference the current
+// version under d.mu and then read without the lock. The memtable keeps
+// taking writes, but readers only look at sequence numbers they were given,
+// so later writes stay invisible.
+//
+// A table a compaction replaced is deleted once no version lists it.
+type version struct {
+	mem    *memtable.Memtable
+	imm    []*immMemtable // oldest first
+	levels compaction.Levels
+	refs   int // guarded by DB.mu; the DB holds one on the current version
+}
+
+// clone returns a copy of v that can be changed and installed.
+func (v *version) clone() *version {
+	nv := &version{mem: v.mem, imm: append([]*immMemtable(nil), v.imm...)}
+	for level, tables := range v.levels {
+		nv.levels[level] = append([]*sstable.Table(nil), tables...)
+	}
+	return nv
+}
+
+func (v *version) numTables() int {
+	n := 0
+	for _, tables := range v.levels {
+		n += len(tables)
+	}
+	return n
+}
+
+// rangeDelSeq returns the highest Seq <= seq of a range tombstone in v that
+// covers key, or 0.
+func (v *version) rangeDelSeq(key []byte, seq uint64) uint64 {
+	s := v.mem.RangeDels().MaxSeq(key, seq)
+	for _, imm := range v.imm {
+		s = max(s, imm.mem.RangeDels().MaxSeq(key, seq))
+	}
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			s = max(s, t.RangeDels().MaxSeq(key, seq))
+		}
+	}
+	return s
+}
+
+// rangeDels returns every range tombstone in v.
+func (v *version) rangeDels() *rangedel.List {
+	ts := slices.Clone(v.mem.RangeDels().Tombstones())
+	for _, imm := range v.imm {
+		ts = append(ts, imm.mem.RangeDels().Tombstones()...)
+	}
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			ts = append(ts, t.RangeDels().Tombstones()...)
+		}
+	}
+	return rangedel.NewList(ts)
+}
+
+// installLocked makes v the current version.
+func (d *DB) installLocked(v *version) {
+	v.refs = 1
+	d.versions[v] = struct{}{}
+	old := d.current
+	d.current = v
+	if old != nil {
+		d.unrefLocked(old)
+	}
+}
+
+// refLocked returns the current version, referenced for the caller.
+func (d *DB) refLocked() *version {
+	d.current.refs++
+	return d.current
+}
+
+func (d *DB) unref(v *version) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unrefLocked(v)
+}
+
+func (d *DB) unrefLocked(v *version) {
+	v.refs--
+	if v.refs > 0 {
+		return
+	}
+	delete(d.versions, v)
+	if len(d.obsolete) > 0 {
+		d.deleteObsoleteTablesLocked()
+	}
+}
+
+// liveTablesLocked returns the IDs of the tables any live version lists.
+func (d *DB) liveTablesLocked() map[uint64]bool {
+	live := make(map[uint64]bool)
+	for v := range d.versions {
+		for _, tables := range v.levels {
+			for _, t := range tables {
+				live[t.ID] = true
+			}
+		}
+	}
+	return live
+}
+
+// deleteObsoleteTablesLocked deletes the replaced tables no version lists
+// anymore.
+func (d *DB) deleteObsoleteTablesLocked() {
+	live := d.liveTablesLocked()
+	kept := d.obsolete[:0]
+	for _, t := range d.obsolete {
+		if live[t.ID] {
+			kept = append(kept, t)
+			continue
+		}
+		_ = os.Remove(t.Path)
+		d.evictTable(t.ID)
+	}
+	clear(d.obsolete[len(kept):])
+	d.obsolete = kept
+}
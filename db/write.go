@@ -0,0 +1,188 @@
+package db
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/rangedel"
+	"github.com/ChinmayNoob/lsm-go/wal"
+)
+
+// maxWriteGroupBytes caps how much a leader merges into one WAL record.
+const maxWriteGroupBytes = 1 << 20
+
+// writer is a Write waiting in d.writers, or (with a nil batch) an
+// ingestion or checkpoint that needs the writes to stop.
+type writer struct {
+	batch *WriteBatch
+	// check, if set, runs when the writer leads, right before its batch is
+	// committed; an error fails the write. Such a writer never joins the
+	// group of another, so check sees every write committed before it.
+	check func() error
+	done  bool
+	err   error
+	cond  sync.Cond // on d.mu
+}
+
+// Write applies every operation in b atomically (see WriteBatch).
+//
+// Concurrent writes are committed in groups: writes queue up in d.writers,
+// and the one at the head (the leader) appends the batches of the writes
+// behind it to the WAL as one record, syncs once, and applies them to the
+// memtable, with d.mu released meanwhile so more writes can queue. Then it
+// hands each of them the result and passes leadership on.
+func (d *DB) Write(b *WriteBatch) error {
+	return d.write(b, nil)
+}
+
+// write is Write with a writer.check.
+func (d *DB) write(b *WriteBatch, check func() error) error {
+	if b == nil || len(b.ops) == 0 {
+		return nil
+	}
+	for _, op := range b.ops {
+		if len(op.Key) == 0 {
+			return ErrEmptyKey
+		}
+		if op.Op == wal.OpRangeDelete && bytes.Compare(op.Key, op.Value) >= 0 {
+			return ErrInvalidRange
+		}
+		if op.Op == wal.OpMerge && d.opts.MergeOperator == nil {
+			return ErrNoMergeOperator
+		}
+	}
+	w := &writer{batch: b, check: check}
+	w.cond.L = &d.mu
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.writers = append(d.writers, w)
+	for !w.done && d.writers[0] != w {
+		w.cond.Wait()
+	}
+	if w.done {
+		return w.err
+	}
+
+	group, err := d.writeGroupLocked()
+	for _, f := range group[1:] {
+		f.err = err
+		f.done = true
+		f.cond.Signal()
+	}
+	d.popWritersLocked(len(group))
+	return err
+}
+
+// leadWriteQueueLocked queues a writer without a batch and waits until it
+// leads, so that no write group runs until popWritersLocked(1).
+func (d *DB) leadWriteQueueLocked() {
+	w := &writer{}
+	w.cond.L = &d.mu
+	d.writers = append(d.writers, w)
+	for d.writers[0] != w {
+		w.cond.Wait()
+	}
+}
+
+// popWritersLocked removes the first n writers from the queue and wakes the
+// next leader.
+func (d *DB) popWritersLocked(n int) {
+	d.writers = d.writers[n:]
+	if len(d.writers) > 0 {
+		d.writers[0].cond.Signal()
+	} else if d.closed {
+		d.bgCond.Broadcast() // Close waits for the queue to drain
+	}
+}
+
+// writeGroupLocked commits the leader's batch together with those queued
+// behind it and returns the writers it covered. Only the leader touches the
+// WAL and the memtable, so it can do both without d.mu; the new sequence
+// numbers become visible once all of the group is in the memtable.
+func (d *DB) writeGroupLocked() ([]*writer, error) {
+	if d.closed {
+		return d.writers[:1], ErrClosed
+	}
+	if d.bgErr != nil {
+		return d.writers[:1], d.bgErr
+	}
+
+	if check := d.writers[0].check; check != nil {
+		if err := check(); err != nil {
+			return d.writers[:1], err
+		}
+	}
+	ops := d.writers[0].batch.ops
+	n := 1
+	size := batchBytes(ops)
+	for _, w := range d.writers[1:] {
+		if w.batch == nil {
+			break // an ingestion or checkpoint, which runs alone
+		}
+		if w.check != nil {
+			break // it has to lead, see writer.check
+		}
+		size += batchBytes(w.batch.ops)
+		if size > maxWriteGroupBytes {
+			break
+		}
+		if n == 1 {
+			ops = append([]wal.Record(nil), ops...)
+		}
+		ops = append(ops, w.batch.ops...)
+		n++
+	}
+	group := d.writers[:n]
+
+	seq := d.seq
+	mem, log := d.current.mem, d.w
+	d.mu.Unlock()
+	err := log.AppendBatch(seq, ops)
+	var added int
+	if err == nil {
+		for i, op := range ops {
+			applyOp(mem, op, seq+uint64(i))
+			added += approxRecordBytes(op.Key, op.Value)
+		}
+	}
+	d.mu.Lock()
+	if err != nil {
+		// The record may have reached the log anyway, and would then be
+		// replayed with the sequence numbers the next group would reuse.
+		d.setBackgroundErrorLocked(err)
+		return group, err
+	}
+	d.seq = seq + uint64(len(ops))
+	d.memBytes += added
+	// The group is committed: a memtable that can't be rotated fails the
+	// writes after it instead.
+	if err := d.maybeRotateLocked(); err != nil {
+		d.setBackgroundErrorLocked(err)
+	}
+	return group, nil
+}
+
+// applyOp adds a WAL record to mem at sequence number seq.
+func applyOp(mem *memtable.Memtable, op wal.Record, seq uint64) {
+	if op.Op == wal.OpRangeDelete {
+		mem.ApplyRangeDelete(rangedel.Tombstone{Start: op.Key, End: op.Value, Seq: seq})
+		return
+	}
+	mem.Apply(memtable.Record{
+		Key:       op.Key,
+		Value:     op.Value,
+		Tombstone: op.Op == wal.OpDelete,
+		Merge:     op.Op == wal.OpMerge,
+		Seq:       seq,
+		ExpiresAt: op.ExpiresAt,
+	})
+}
+
+func batchBytes(ops []wal.Record) int {
+	n := 0
+	for _, op := range ops {
+		n += approxRecordBytes(op.Key, op.Value)
+	}
+	return n
+}
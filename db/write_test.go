@@ -0,0 +1,96 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/ChinmayNoob/lsm-go/wal"
+)
+
+func openTestDB(t *testing.T, opts Options) *DB {
+	t.Helper()
+	if opts.Dir == "" {
+		opts.Dir = t.TempDir()
+	}
+	opts.SyncOnWrite = false
+	d, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestWriteLogFailureStopsWrites(t *testing.T) {
+	d := openTestDB(t, DefaultOptions())
+	if err := d.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	seq := d.seq
+	_ = d.w.Close() // the next append fails
+	d.mu.Unlock()
+
+	err := d.Put([]byte("b"), []byte("2"))
+	if err == nil {
+		t.Fatal("Put with a broken log succeeded")
+	}
+	// Even with the log working again, appending after a record that may be
+	// half written would reuse its sequence numbers.
+	d.mu.Lock()
+	d.w, _ = wal.Open(d.logPath(d.logNum), false)
+	d.mu.Unlock()
+	if err2 := d.Put([]byte("c"), []byte("3")); !errors.Is(err2, err) {
+		t.Fatalf("Put after a log failure = %v, want %v", err2, err)
+	}
+	d.mu.Lock()
+	if d.seq != seq {
+		t.Errorf("seq = %d after failed writes, want %d", d.seq, seq)
+	}
+	d.mu.Unlock()
+	if _, ok, _ := d.Get([]byte("b")); ok {
+		t.Error("failed write is visible")
+	}
+	if v, _, _ := d.Get([]byte("a")); string(v) != "1" {
+		t.Errorf("Get(a) = %q, want 1", v)
+	}
+	if err := d.Close(); err == nil {
+		t.Error("Close didn't report the log failure")
+	}
+}
+
+func TestWriteRotationFailureKeepsCommittedWrite(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Dir = t.TempDir()
+	opts.MemtableMaxBytes = 1 // every write rotates
+	d := openTestDB(t, opts)
+	d.mu.Lock()
+	blocked := d.logPath(d.nextFile)
+	d.mu.Unlock()
+	// The next log can't be created where a directory is in the way.
+	if err := os.Mkdir(blocked, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("committed Put failed: %v", err)
+	}
+	if v, _, _ := d.Get([]byte("a")); string(v) != "1" {
+		t.Fatalf("Get(a) = %q, want 1", v)
+	}
+	if err := d.Put([]byte("b"), []byte("2")); err == nil {
+		t.Fatal("Put after a rotation failure succeeded")
+	}
+	if err := d.Close(); err == nil {
+		t.Error("Close didn't report the rotation failure")
+	}
+
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+	d = openTestDB(t, opts)
+	defer func() { _ = d.Close() }()
+	if v, _, _ := d.Get([]byte("a")); string(v) != "1" {
+		t.Errorf("after reopen Get(a) = %q, want 1", v)
+	}
+}
@@ -0,0 +1,22 @@
+package iterator
+
+import "github.com/ChinmayNoob/lsm-go/memtable"
+
+// Iterator walks records in internal order: key ascending, then Seq
+// descending (see memtable.Compare). Every version of a key is returned,
+// including tombstones; hiding them is up to the caller.
+//
+// Record is only valid while Valid reports true, and its slices may be
+// reused once the iterator moves.
+type Iterator interface {
+	First()
+	Last()
+	// SeekGE moves to the first record whose key is >= key.
+	SeekGE(key []byte)
+	Next()
+	Prev()
+	Valid() bool
+	Record() memtable.Record
+	Err() error
+	Close() error
+}
@@ -0,0 +1,169 @@
+package iterator
+
+import (
+	"container/heap"
+
+	"github.com/ChinmayNoob/lsm-go/memtable"
+)
+
+type direction int
+
+const (
+	forward direction = iota
+	reverse
+)
+
+// merging does a k-way merge of its children using a heap. Going forward the
+// heap is a min-heap on internal order, going backward a max-heap; switching
+// direction repositions every child around the current record.
+type merging struct {
+	children []Iterator
+	h        mergeHeap
+	dir      direction
+}
+
+// NewMerging returns an iterator over the union of children in internal
+// order. Closing it closes every child.
+func NewMerging(children ...Iterator) Iterator {
+	return &merging{
+		children: children,
+		h:        mergeHeap{its: make([]Iterator, 0, len(children))},
+	}
+}
+
+func (m *merging) First() {
+	for _, c := range m.children {
+		c.First()
+	}
+	m.rebuild(forward)
+}
+
+func (m *merging) Last() {
+	for _, c := range m.children {
+		c.Last()
+	}
+	m.rebuild(reverse)
+}
+
+func (m *merging) SeekGE(key []byte) {
+	for _, c := range m.children {
+		c.SeekGE(key)
+	}
+	m.rebuild(forward)
+}
+
+func (m *merging) Next() {
+	if !m.Valid() {
+		return
+	}
+	if m.dir == reverse {
+		// Move every child to the first record after the current one.
+		cur := m.Record()
+		cur.Key = append([]byte(nil), cur.Key...)
+		for _, c := range m.children {
+			c.SeekGE(cur.Key)
+			for c.Valid() && memtable.Compare(c.Record(), cur) <= 0 {
+				c.Next()
+			}
+		}
+		m.rebuild(forward)
+		return
+	}
+	top := m.h.its[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(&m.h, 0)
+	} else {
+		heap.Pop(&m.h)
+	}
+}
+
+func (m *merging) Prev() {
+	if !m.Valid() {
+		return
+	}
+	if m.dir == forward {
+		// Move every child to the last record before the current one.
+		cur := m.Record()
+		cur.Key = append([]byte(nil), cur.Key...)
+		for _, c := range m.children {
+			c.SeekGE(cur.Key)
+			for c.Valid() && memtable.Compare(c.Record(), cur) < 0 {
+				c.Next()
+			}
+			if c.Valid() {
+				c.Prev()
+			} else {
+				c.Last()
+			}
+		}
+		m.rebuild(reverse)
+		return
+	}
+	top := m.h.its[0]
+	top.Prev()
+	if top.Valid() {
+		heap.Fix(&m.h, 0)
+	} else {
+		heap.Pop(&m.h)
+	}
+}
+
+func (m *merging) Valid() bool { return len(m.h.its) > 0 && m.Err() == nil }
+
+func (m *merging) Record() memtable.Record { return m.h.its[0].Record() }
+
+func (m *merging) Err() error {
+	for _, c := range m.children {
+		if err := c.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *merging) Close() error {
+	var first error
+	for _, c := range m.children {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	m.h.its = m.h.its[:0]
+	return first
+}
+
+func (m *merging) rebuild(dir direction) {
+	m.dir = dir
+	m.h.reverse = dir == reverse
+	m.h.its = m.h.its[:0]
+	for _, c := range m.children {
+		if c.Valid() {
+			m.h.its = append(m.h.its, c)
+		}
+	}
+	heap.Init(&m.h)
+}
+
+type mergeHeap struct {
+	its     []Iterator
+	reverse bool
+}
+
+func (h mergeHeap) Len() int { return len(h.its) }
+func (h mergeHeap) Less(i, j int) bool {
+	c := memtable.Compare(h.its[i].Record(), h.its[j].Record())
+	if h.reverse {
+		return c > 0
+	}
+	return c < 0
+}
+func (h mergeHeap) Swap(i, j int) { h.its[i], h.its[j] = h.its[j], h.its[i] }
+func (h *mergeHeap) Push(x any)   { h.its = append(h.its, x.(Iterator)) }
+func (h *mergeHeap) Pop() any {
+	old := h.its
+	n := len(old)
+	x := old[n-1]
+	h.its = old[:n-1]
+	return x
+}
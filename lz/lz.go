@@ -0,0 +1,167 @@
+package lz
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// A small LZ77 codec in the spirit of LZ4, tuned for SSTable blocks: no
+// entropy coding, one pass, 64 KiB window.
+//
+// Encoded layout:
+//
+//	[uvarint decodedLen] sequence*
+//	sequence: [u8 token][literal len ext*][literals][u16 offset][match len ext*]
+//
+// The high nibble of the token is the literal length, the low nibble the
+// match length minus minMatch. A nibble of 15 is continued by extension bytes
+// that are added to it, each 255 meaning another byte follows. The last
+// sequence carries literals only and ends the input.
+
+var ErrCorrupt = errors.New("lz: corrupt input")
+
+const (
+	minMatch  = 4
+	maxOffset = 1<<16 - 1
+	hashLog   = 14
+)
+
+// Encode appends the encoding of src to dst and returns the result.
+func Encode(dst, src []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+
+	var table [1 << hashLog]int32 // position+1 of the last 4 bytes with that hash
+	anchor, i := 0, 0
+	for i+minMatch <= len(src) {
+		h := hash(binary.LittleEndian.Uint32(src[i:]))
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > maxOffset ||
+			binary.LittleEndian.Uint32(src[cand:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+		n := minMatch
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		dst = appendSequence(dst, src[anchor:i], i-cand, n)
+		i += n
+		anchor = i
+	}
+	return appendSequence(dst, src[anchor:], 0, 0)
+}
+
+// appendSequence writes literals followed by a match of length n at offset
+// off. A zero n ends the stream.
+func appendSequence(dst, lit []byte, off, n int) []byte {
+	litNib := min(len(lit), 15)
+	matchNib := 0
+	if n > 0 {
+		matchNib = min(n-minMatch, 15)
+	}
+	dst = append(dst, byte(litNib<<4|matchNib))
+	if litNib == 15 {
+		dst = appendExt(dst, len(lit)-15)
+	}
+	dst = append(dst, lit...)
+	if n == 0 {
+		return dst
+	}
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(off))
+	if matchNib == 15 {
+		dst = appendExt(dst, n-minMatch-15)
+	}
+	return dst
+}
+
+func appendExt(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+// Decode appends the decoding of src to dst and returns the result.
+func Decode(dst, src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 || size > 1<<31 {
+		return nil, ErrCorrupt
+	}
+	src = src[n:]
+	base := len(dst)
+	want := base + int(size)
+	if cap(dst) < want {
+		grown := make([]byte, len(dst), want)
+		copy(grown, dst)
+		dst = grown
+	}
+
+	for len(src) > 0 {
+		token := src[0]
+		src = src[1:]
+
+		litLen := int(token >> 4)
+		if litLen == 15 {
+			ext, ok := readExt(&src)
+			if !ok {
+				return nil, ErrCorrupt
+			}
+			litLen += ext
+		}
+		if litLen > len(src) || len(dst)+litLen > want {
+			return nil, ErrCorrupt
+		}
+		dst = append(dst, src[:litLen]...)
+		src = src[litLen:]
+		if len(src) == 0 {
+			break
+		}
+
+		if len(src) < 2 {
+			return nil, ErrCorrupt
+		}
+		off := int(binary.LittleEndian.Uint16(src))
+		src = src[2:]
+		matchLen := int(token&0x0f) + minMatch
+		if token&0x0f == 15 {
+			ext, ok := readExt(&src)
+			if !ok {
+				return nil, ErrCorrupt
+			}
+			matchLen += ext
+		}
+		if off == 0 || off > len(dst)-base || len(dst)+matchLen > want {
+			return nil, ErrCorrupt
+		}
+		// Byte by byte, since the match may overlap what it produces.
+		start := len(dst) - off
+		for j := 0; j < matchLen; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if len(dst) != want {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
+
+func readExt(src *[]byte) (int, bool) {
+	n := 0
+	for {
+		if len(*src) == 0 {
+			return 0, false
+		}
+		b := (*src)[0]
+		*src = (*src)[1:]
+		n += int(b)
+		if b != 255 {
+			return n, true
+		}
+	}
+}
+
+func hash(u uint32) uint32 {
+	return (u * 2654435761) >> (32 - hashLog)
+}
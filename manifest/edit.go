@@ -0,0 +1,136 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// VersionEdit is one change to the table set. Nil counters are left as they
+// are.
+type VersionEdit struct {
+	LogNumber   *uint64
+	NextFileNum *uint64
+	LastSeq     *uint64
+	Added       []FileMeta
+	Removed     []FileMeta
+}
+
+// Edit bodies are a sequence of tagged fields:
+//
+//	tagLogNumber   [uvarint]
+//	tagNextFileNum [uvarint]
+//	tagLastSeq     [uvarint]
+//	tagAdded       [uvarint level][uvarint num]
+//	tagRemoved     [uvarint level][uvarint num]
+//	tagIngested    [uvarint level][uvarint num][uvarint globalSeq]
+//
+// An added table with a GlobalSeq is recorded as tagIngested.
+const (
+	tagLogNumber   = 1
+	tagNextFileNum = 2
+	tagLastSeq     = 3
+	tagAdded       = 4
+	tagRemoved     = 5
+	tagIngested    = 6
+)
+
+func (e *VersionEdit) encode() []byte {
+	var b []byte
+	if e.LogNumber != nil {
+		b = binary.AppendUvarint(append(b, tagLogNumber), *e.LogNumber)
+	}
+	if e.NextFileNum != nil {
+		b = binary.AppendUvarint(append(b, tagNextFileNum), *e.NextFileNum)
+	}
+	if e.LastSeq != nil {
+		b = binary.AppendUvarint(append(b, tagLastSeq), *e.LastSeq)
+	}
+	for _, f := range e.Removed {
+		b = binary.AppendUvarint(append(b, tagRemoved), uint64(f.Level))
+		b = binary.AppendUvarint(b, f.Num)
+	}
+	for _, f := range e.Added {
+		if f.GlobalSeq != 0 {
+			b = binary.AppendUvarint(append(b, tagIngested), uint64(f.Level))
+			b = binary.AppendUvarint(b, f.Num)
+			b = binary.AppendUvarint(b, f.GlobalSeq)
+			continue
+		}
+		b = binary.AppendUvarint(append(b, tagAdded), uint64(f.Level))
+		b = binary.AppendUvarint(b, f.Num)
+	}
+	return b
+}
+
+func (e *VersionEdit) decode(b []byte) error {
+	next := func() (uint64, error) {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, fmt.Errorf("%w: bad varint in edit", ErrCorrupt)
+		}
+		b = b[n:]
+		return v, nil
+	}
+	for len(b) > 0 {
+		tag := b[0]
+		b = b[1:]
+		switch tag {
+		case tagLogNumber, tagNextFileNum, tagLastSeq:
+			v, err := next()
+			if err != nil {
+				return err
+			}
+			switch tag {
+			case tagLogNumber:
+				e.LogNumber = &v
+			case tagNextFileNum:
+				e.NextFileNum = &v
+			default:
+				e.LastSeq = &v
+			}
+		case tagAdded, tagRemoved, tagIngested:
+			level, err := next()
+			if err != nil {
+				return err
+			}
+			num, err := next()
+			if err != nil {
+				return err
+			}
+			f := FileMeta{Level: int(level), Num: num}
+			switch tag {
+			case tagAdded:
+				e.Added = append(e.Added, f)
+			case tagRemoved:
+				e.Removed = append(e.Removed, f)
+			default:
+				if f.GlobalSeq, err = next(); err != nil {
+					return err
+				}
+				e.Added = append(e.Added, f)
+			}
+		default:
+			return fmt.Errorf("%w: unknown edit tag %d", ErrCorrupt, tag)
+		}
+	}
+	return nil
+}
+
+func (st *State) apply(e *VersionEdit) {
+	if e.LogNumber != nil {
+		st.LogNumber = *e.LogNumber
+	}
+	if e.NextFileNum != nil {
+		st.NextFileNum = *e.NextFileNum
+	}
+	if e.LastSeq != nil {
+		st.LastSeq = *e.LastSeq
+	}
+	for _, r := range e.Removed {
+		st.Files = slices.DeleteFunc(st.Files, func(f FileMeta) bool { return f.Num == r.Num })
+	}
+	for _, a := range e.Added {
+		st.Files = append(st.Files, a)
+	}
+}
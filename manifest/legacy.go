@@ -0,0 +1,48 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// LegacyFilename is the single-snapshot manifest written before the edit log:
+//
+//	[u32 crc32c(body)][body]
+//	body: [uvarint nextFileNum][uvarint n] ([uvarint level][uvarint num])*n
+const LegacyFilename = "MANIFEST"
+
+// LoadLegacy reads a snapshot manifest. It returns os.ErrNotExist (wrapped)
+// if there is none.
+func LoadLegacy(dir string) (State, error) {
+	b, err := os.ReadFile(filepath.Join(dir, LegacyFilename))
+	if err != nil {
+		return State{}, err
+	}
+	if len(b) < 4 {
+		return State{}, ErrCorrupt
+	}
+	body := b[4:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(b[:4]) {
+		return State{}, ErrCorrupt
+	}
+
+	var vals []uint64
+	for len(body) > 0 {
+		v, n := binary.Uvarint(body)
+		if n <= 0 {
+			return State{}, ErrCorrupt
+		}
+		vals = append(vals, v)
+		body = body[n:]
+	}
+	if len(vals) < 2 || uint64(len(vals)-2) != 2*vals[1] {
+		return State{}, ErrCorrupt
+	}
+	st := State{NextFileNum: vals[0]}
+	for i := 2; i < len(vals); i += 2 {
+		st.Files = append(st.Files, FileMeta{Level: int(vals[i]), Num: vals[i+1]})
+	}
+	return st, nil
+}
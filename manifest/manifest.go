@@ -0,0 +1,210 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The manifest is the authoritative record of the live SSTables, the level
+// each one belongs to, and the counters needed to reopen the DB. It is an
+// append-only log of VersionEdits in a MANIFEST-<num> file; CURRENT names the
+// manifest in use. Every flush or compaction is committed by one synced edit,
+// so a crash leaves either the old or the new table set. Files that no edit
+// references are leftovers and are garbage-collected.
+//
+// Records are framed as [u32 len][u32 crc32c(body)][body]. A record cut short
+// at the end of the file is an edit that was never committed and is ignored.
+const CurrentFilename = "CURRENT"
+
+var ErrCorrupt = errors.New("manifest: corrupt")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Filename returns the name of manifest num.
+func Filename(num uint64) string {
+	return fmt.Sprintf("MANIFEST-%06d", num)
+}
+
+// ParseFilename returns the number of a MANIFEST-<num> file name.
+func ParseFilename(name string) (uint64, bool) {
+	s, ok := strings.CutPrefix(name, "MANIFEST-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
+
+// FileMeta places one table in the LSM tree.
+type FileMeta struct {
+	Level int
+	Num   uint64
+	// GlobalSeq, if not zero, is the sequence number every entry of an
+	// ingested table reads with.
+	GlobalSeq uint64
+}
+
+// State is the result of replaying every edit of a manifest.
+type State struct {
+	// LogNumber is the oldest WAL that still holds unflushed writes; older
+	// logs are obsolete.
+	LogNumber   uint64
+	NextFileNum uint64
+	LastSeq     uint64
+	Files       []FileMeta
+}
+
+// Manifest appends edits to an open MANIFEST file.
+type Manifest struct {
+	dir   string
+	num   uint64
+	f     *os.File
+	state State
+	err   error // sticky: a failed write may have left a partial record
+}
+
+// Load reads the manifest CURRENT points to and returns the state its edits
+// add up to. It returns os.ErrNotExist (wrapped) if there is no CURRENT yet.
+func Load(dir string) (State, error) {
+	cur, err := os.ReadFile(filepath.Join(dir, CurrentFilename))
+	if err != nil {
+		return State{}, err
+	}
+	name := strings.TrimSuffix(string(cur), "\n")
+	if _, ok := ParseFilename(name); !ok {
+		return State{}, fmt.Errorf("%w: CURRENT names %q", ErrCorrupt, name)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return State{}, err
+	}
+
+	var st State
+	for len(b) > 0 {
+		if len(b) < 8 {
+			break // torn tail
+		}
+		n := binary.LittleEndian.Uint32(b[0:4])
+		if uint64(n) > uint64(len(b)-8) {
+			break // torn tail
+		}
+		body := b[8 : 8+n]
+		if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(b[4:8]) {
+			if len(b) == int(8+n) {
+				break // torn tail
+			}
+			return State{}, fmt.Errorf("%w: %s: checksum mismatch", ErrCorrupt, name)
+		}
+		var e VersionEdit
+		if err := e.decode(body); err != nil {
+			return State{}, fmt.Errorf("%s: %w", name, err)
+		}
+		st.apply(&e)
+		b = b[8+n:]
+	}
+	return st, nil
+}
+
+// Create starts manifest num holding st as its first edit, and points
+// CURRENT at it.
+func Create(dir string, num uint64, st State) (*Manifest, error) {
+	path := filepath.Join(dir, Filename(num))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{dir: dir, num: num, f: f}
+	snap := VersionEdit{
+		LogNumber:   &st.LogNumber,
+		NextFileNum: &st.NextFileNum,
+		LastSeq:     &st.LastSeq,
+		Added:       st.Files,
+	}
+	if err := m.Apply(&snap); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	if err := setCurrent(dir, Filename(num)); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Num returns the file number of the manifest.
+func (m *Manifest) Num() uint64 { return m.num }
+
+// State returns the state after every edit applied so far.
+func (m *Manifest) State() State {
+	st := m.state
+	st.Files = slices.Clone(st.Files)
+	return st
+}
+
+// Apply appends e and syncs it; once it returns nil the edit is committed.
+// After a failed Apply every later one fails too.
+func (m *Manifest) Apply(e *VersionEdit) error {
+	if m.err != nil {
+		return m.err
+	}
+	body := e.encode()
+	rec := binary.LittleEndian.AppendUint32(nil, uint32(len(body)))
+	rec = binary.LittleEndian.AppendUint32(rec, crc32.Checksum(body, crcTable))
+	rec = append(rec, body...)
+	if _, err := m.f.Write(rec); err != nil {
+		m.err = err
+		return err
+	}
+	if err := m.f.Sync(); err != nil {
+		m.err = err
+		return err
+	}
+	m.state.apply(e)
+	return nil
+}
+
+func (m *Manifest) Close() error {
+	return m.f.Close()
+}
+
+// setCurrent atomically points CURRENT at name.
+func setCurrent(dir, name string) error {
+	path := filepath.Join(dir, CurrentFilename)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(name + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+	return d.Sync()
+}
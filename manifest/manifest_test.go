@@ -0,0 +1,129 @@
+package manifest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func u64(n uint64) *uint64 { return &n }
+
+// writeManifest creates manifest 1 in a new directory and applies edits. It
+// returns the directory, the manifest's path, the offset each edit ends at
+// and the state after each edit.
+func writeManifest(t *testing.T, edits ...VersionEdit) (string, string, []int64, []State) {
+	t.Helper()
+	dir := t.TempDir()
+	m, err := Create(dir, 1, State{LogNumber: 1, NextFileNum: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, Filename(1))
+	size := func() int64 {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	ends := []int64{size()}
+	states := []State{m.State()}
+	for _, e := range edits {
+		if err := m.Apply(&e); err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, size())
+		states = append(states, m.State())
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir, path, ends, states
+}
+
+var testEdits = []VersionEdit{
+	{NextFileNum: u64(4), LastSeq: u64(10), Added: []FileMeta{{Level: 0, Num: 2}, {Level: 0, Num: 3}}},
+	{LogNumber: u64(5), NextFileNum: u64(6), Added: []FileMeta{{Level: 6, Num: 4, GlobalSeq: 11}}},
+	{NextFileNum: u64(8), LastSeq: u64(20), Removed: []FileMeta{{Level: 0, Num: 2}, {Level: 0, Num: 3}},
+		Added: []FileMeta{{Level: 1, Num: 7}}},
+}
+
+func TestLoad(t *testing.T) {
+	dir, _, _, states := writeManifest(t, testEdits...)
+	st, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := State{
+		LogNumber:   5,
+		NextFileNum: 8,
+		LastSeq:     20,
+		Files:       []FileMeta{{Level: 6, Num: 4, GlobalSeq: 11}, {Level: 1, Num: 7}},
+	}
+	if !reflect.DeepEqual(st, want) || !reflect.DeepEqual(st, states[len(states)-1]) {
+		t.Fatalf("Load = %+v, want %+v", st, want)
+	}
+}
+
+func TestLoadNoCurrent(t *testing.T) {
+	if _, err := Load(t.TempDir()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("err = %v, want os.ErrNotExist", err)
+	}
+}
+
+// A crash while appending an edit leaves part of it at the end of the
+// manifest. Wherever the edit was cut, Load returns the state before it.
+func TestLoadTornTail(t *testing.T) {
+	dir, path, ends, states := writeManifest(t, testEdits...)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := len(ends) - 1
+	for cut := ends[last-1]; cut < ends[last]; cut++ {
+		if err := os.WriteFile(path, b[:cut], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		st, err := Load(dir)
+		if err != nil {
+			t.Fatalf("cut at %d: %v", cut, err)
+		}
+		if !reflect.DeepEqual(st, states[last-1]) {
+			t.Fatalf("cut at %d: Load = %+v, want %+v", cut, st, states[last-1])
+		}
+	}
+}
+
+func TestLoadChecksumMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		edit int // the damaged edit, counting the one Create writes as 0
+		err  error
+	}{
+		{name: "in the middle", edit: 1, err: ErrCorrupt},
+		// A bad checksum on the last edit is a torn write.
+		{name: "at the end", edit: len(testEdits), err: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, path, ends, states := writeManifest(t, testEdits...)
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b[ends[tt.edit]-1] ^= 0x01
+			if err := os.WriteFile(path, b, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			st, err := Load(dir)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && !reflect.DeepEqual(st, states[tt.edit-1]) {
+				t.Fatalf("Load = %+v, want %+v", st, states[tt.edit-1])
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package memtable
+
+import "sync/atomic"
+
+const (
+	arenaBlockSize = 64 << 10
+	nodeBlockLen   = 256
+	towerBlockLen  = 1024
+)
+
+// arena hands out nodes, towers and key/value bytes from large blocks so a
+// memtable with millions of entries costs a few thousand allocations instead
+// of millions. Blocks are never moved or reused, which keeps pointers into
+// them valid for concurrent readers. Only the writer may allocate.
+type arena struct {
+	buf   []byte
+	nodes []node
+	tower []atomic.Pointer[node]
+}
+
+func (a *arena) newNode(height int) *node {
+	if len(a.nodes) == 0 {
+		a.nodes = make([]node, nodeBlockLen)
+	}
+	n := &a.nodes[0]
+	a.nodes = a.nodes[1:]
+
+	if len(a.tower) < height {
+		a.tower = make([]atomic.Pointer[node], towerBlockLen)
+	}
+	n.next = a.tower[:height:height]
+	a.tower = a.tower[height:]
+	return n
+}
+
+// copyBytes returns a copy of b that lives in the arena. Like cloneBytes it
+// keeps nil distinct from empty.
+func (a *arena) copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	if len(b) == 0 {
+		return []byte{}
+	}
+	if len(b) > arenaBlockSize/4 {
+		// Big values get their own allocation instead of wasting most of a
+		// block.
+		return cloneBytes(b)
+	}
+	if len(a.buf) < len(b) {
+		a.buf = make([]byte, arenaBlockSize)
+	}
+	out := a.buf[:len(b):len(b)]
+	copy(out, b)
+	a.buf = a.buf[len(b):]
+	return out
+}
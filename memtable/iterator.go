@@ -0,0 +1,44 @@
+package memtable
+
+// Iterator walks the memtable in internal order (see Compare). It reads the
+// live skiplist, so records applied after it was created may or may not be
+// seen; callers that need a stable view filter by Seq.
+type Iterator struct {
+	list *skiplist
+	n    *node
+}
+
+func (m *Memtable) NewIterator() *Iterator {
+	return &Iterator{list: m.list}
+}
+
+func (it *Iterator) First() { it.n = it.list.head.next[0].Load() }
+
+func (it *Iterator) Last() { it.n = it.list.findLast() }
+
+// SeekGE moves to the first record whose key is >= key.
+func (it *Iterator) SeekGE(key []byte) {
+	it.n = it.list.findGreaterOrEqual(Record{Key: key, Seq: ^uint64(0)}, nil)
+}
+
+func (it *Iterator) Next() {
+	if it.Valid() {
+		it.n = it.n.next[0].Load()
+	}
+}
+
+// Prev searches from the head since nodes only link forward.
+func (it *Iterator) Prev() {
+	if it.Valid() {
+		it.n = it.list.findLessThan(it.n.rec)
+	}
+}
+
+func (it *Iterator) Valid() bool { return it.n != nil }
+
+// Record returns the current record. The slices must not be modified.
+func (it *Iterator) Record() Record { return it.n.rec }
+
+func (it *Iterator) Err() error { return nil }
+
+func (it *Iterator) Close() error { return nil }
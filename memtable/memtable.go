@@ -1,65 +1,73 @@
 package memtable
 
-import "bytes"
+import (
+	"bytes"
+	"slices"
+	"sync/atomic"
 
+	"github.com/ChinmayNoob/lsm-go/rangedel"
+)
+
+// Memtable holds recent writes in a skiplist ordered by key, then by Seq
+// descending, so every version of a key is kept (snapshots may still read
+// older ones) and ordered iteration needs no sorting.
+//
+// Range tombstones are kept beside the skiplist; readers combine the two.
+//
+// Apply and ApplyRangeDelete calls must be serialized by the caller; Get,
+// GetAt, RangeDels and iterators are safe to use concurrently with them.
 type Memtable struct {
-	byKey map[string]Record
+	list      *skiplist
+	rangeDels atomic.Pointer[rangedel.List] // replaced on every range delete
 }
 
 func New() *Memtable {
 	return &Memtable{
-		byKey: make(map[string]Record),
+		list: newSkiplist(),
 	}
 }
 
-// applies record if it is newer than the current record 
+// applies record as a new version of its key; a record with the same key
+// and Seq as an existing one is ignored
 func (m *Memtable) Apply(r Record) {
-	k := string(r.Key)
-	curr, ok := m.byKey[k]
+	m.list.insert(r)
+}
 
-	if !ok || r.Seq >= curr.Seq {
-		//copy bytes so that cant mutate internal state
-		m.byKey[k] = Record{
-			Key:       cloneBytes(r.Key),
-			Value:     cloneBytes(r.Value),
-			Tombstone: r.Tombstone,
-			Seq:       r.Seq,
-		}
+// ApplyRangeDelete adds range tombstones.
+func (m *Memtable) ApplyRangeDelete(ts ...rangedel.Tombstone) {
+	all := slices.Clone(m.RangeDels().Tombstones())
+	for _, t := range ts {
+		t.Start = cloneBytes(t.Start)
+		t.End = cloneBytes(t.End)
+		all = append(all, t)
 	}
+	m.rangeDels.Store(rangedel.NewList(all))
+}
+
+// RangeDels returns the range tombstones applied so far.
+func (m *Memtable) RangeDels() *rangedel.List {
+	return m.rangeDels.Load()
 }
 
-//returns latest recs
+// returns latest recs
 func (m *Memtable) Get(key []byte) (Record, bool) {
-	r, ok := m.byKey[string(key)]
+	return m.GetAt(key, ^uint64(0))
+}
 
-	if !ok {
+// GetAt returns the newest version of key with Seq <= seq.
+func (m *Memtable) GetAt(key []byte, seq uint64) (Record, bool) {
+	// The first entry >= (key, seq) is the newest version at or below seq.
+	n := m.list.findGreaterOrEqual(Record{Key: key, Seq: seq}, nil)
+	if n == nil || !bytes.Equal(n.rec.Key, key) {
 		return Record{}, false
 	}
-
+	r := n.rec
+	//copy bytes so that cant mutate internal state
 	r.Key = cloneBytes(r.Key)
 	r.Value = cloneBytes(r.Value)
 	return r, true
 }
 
-func (m *Memtable) KeysSorted() [][]byte {
-	keys := make([][]byte, 0, len(m.byKey))
-	for _, r := range m.byKey {
-		keys = append(keys, cloneBytes(r.Key))
-	}
-	sortBytesSlices(keys)
-	return keys
-}
-
-func sortBytesSlices(keys [][]byte) {
-	for i := 0; i < len(keys); i++ {
-		for j := i + 1; j < len(keys); j++ {
-			if bytes.Compare(keys[j], keys[i]) < 0 {
-				keys[i], keys[j] = keys[j], keys[i]
-			}
-		}
-	}
-}
-
 func cloneBytes(b []byte) []byte {
 	if b == nil {
 		return nil
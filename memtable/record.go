@@ -1,11 +1,36 @@
 package memtable
 
+import "bytes"
+
 type Record struct {
-	Key []byte
-	Value []byte
+	Key       []byte
+	Value     []byte
 	Tombstone bool
-	Seq uint64
+	Merge     bool // Value is a DB.Merge operand, not the key's value
+	Seq       uint64
+	ExpiresAt int64 // Unix nanoseconds; 0 if the value never expires
 }
 
 // seq is a monotonically increasing sequence number
 //tombstone means the key is deleted at Seq
+
+// Expired reports whether r is a value that has expired by now (Unix
+// nanoseconds). An expired value reads like a tombstone.
+func (r Record) Expired(now int64) bool {
+	return r.ExpiresAt != 0 && r.ExpiresAt <= now
+}
+
+// Compare orders records by key ascending, then by Seq descending, so the
+// newest version of a key comes first.
+func Compare(a, b Record) int {
+	if c := bytes.Compare(a.Key, b.Key); c != 0 {
+		return c
+	}
+	switch {
+	case a.Seq > b.Seq:
+		return -1
+	case a.Seq < b.Seq:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package memtable
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+const maxHeight = 12
+
+// node is a skiplist entry. next[i] links to the following node at level i;
+// len(next) is the node's height.
+type node struct {
+	rec  Record
+	next []atomic.Pointer[node]
+}
+
+// skiplist keeps records in internal order (see Compare). A single writer may
+// insert while any number of readers search and iterate: a node is fully
+// built before it is published with an atomic store, and nodes are never
+// removed.
+type skiplist struct {
+	head   *node
+	height atomic.Int32
+	arena  arena
+}
+
+func newSkiplist() *skiplist {
+	s := &skiplist{head: &node{next: make([]atomic.Pointer[node], maxHeight)}}
+	s.height.Store(1)
+	return s
+}
+
+// insert adds r unless a record with the same key and Seq is already present.
+// Callers must serialize inserts.
+func (s *skiplist) insert(r Record) {
+	var prev [maxHeight]*node
+	if x := s.findGreaterOrEqual(r, &prev); x != nil && Compare(x.rec, r) == 0 {
+		return
+	}
+
+	h := randomHeight()
+	if cur := int(s.height.Load()); h > cur {
+		for i := cur; i < h; i++ {
+			prev[i] = s.head
+		}
+		// Readers that see the new height before the node is linked just
+		// find nil at the upper levels of head and move down.
+		s.height.Store(int32(h))
+	}
+
+	n := s.arena.newNode(h)
+	n.rec = Record{
+		Key:       s.arena.copyBytes(r.Key),
+		Value:     s.arena.copyBytes(r.Value),
+		Tombstone: r.Tombstone,
+		Merge:     r.Merge,
+		Seq:       r.Seq,
+		ExpiresAt: r.ExpiresAt,
+	}
+	for i := 0; i < h; i++ {
+		n.next[i].Store(prev[i].next[i].Load())
+		prev[i].next[i].Store(n)
+	}
+}
+
+// findGreaterOrEqual returns the first node >= target, or nil. If prev is not
+// nil it is filled with the last node < target at every level.
+func (s *skiplist) findGreaterOrEqual(target Record, prev *[maxHeight]*node) *node {
+	x := s.head
+	level := int(s.height.Load()) - 1
+	for {
+		next := x.next[level].Load()
+		if next != nil && Compare(next.rec, target) < 0 {
+			x = next
+			continue
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+		if level == 0 {
+			return next
+		}
+		level--
+	}
+}
+
+// findLessThan returns the last node < target, or nil.
+func (s *skiplist) findLessThan(target Record) *node {
+	x := s.head
+	level := int(s.height.Load()) - 1
+	for {
+		next := x.next[level].Load()
+		if next != nil && Compare(next.rec, target) < 0 {
+			x = next
+			continue
+		}
+		if level == 0 {
+			break
+		}
+		level--
+	}
+	if x == s.head {
+		return nil
+	}
+	return x
+}
+
+// findLast returns the last node, or nil if the list is empty.
+func (s *skiplist) findLast() *node {
+	x := s.head
+	level := int(s.height.Load()) - 1
+	for {
+		if next := x.next[level].Load(); next != nil {
+			x = next
+			continue
+		}
+		if level == 0 {
+			break
+		}
+		level--
+	}
+	if x == s.head {
+		return nil
+	}
+	return x
+}
+
+// randomHeight picks a height with branching factor 4.
+func randomHeight() int {
+	h := 1
+	for h < maxHeight && rand.IntN(4) == 0 {
+		h++
+	}
+	return h
+}
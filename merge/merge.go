@@ -0,0 +1,111 @@
+// Package merge defines merge operators: how DB.Merge operands combine with
+// a key's value, so a read-modify-write like incrementing a counter becomes
+// a blind write that reads resolve later.
+package merge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidOperand = errors.New("invalid merge operand")
+
+// Operator combines merge operands with the value they apply to. A DB must
+// be opened with the same operator every time: operands are stored as
+// written and only resolved when read or compacted.
+type Operator interface {
+	// Merge returns the value of key after applying operands, oldest
+	// first, to existing, which is nil if the key had no value.
+	Merge(key, existing []byte, operands [][]byte) ([]byte, error)
+	// PartialMerge combines operands, oldest first, into one operand with
+	// the same effect, or returns false if they can't be combined without
+	// the value they apply to.
+	PartialMerge(key []byte, operands [][]byte) ([]byte, bool)
+}
+
+// Uint64Add returns an operator treating values and operands as uint64
+// counters, encoded with EncodeUint64, and adding the operands up. A missing
+// or empty value counts as 0; sums wrap around.
+func Uint64Add() Operator { return uint64Add{} }
+
+type uint64Add struct{}
+
+func (uint64Add) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum uint64
+	if len(existing) > 0 {
+		n, err := DecodeUint64(existing)
+		if err != nil {
+			return nil, fmt.Errorf("%w: value of %q: %w", ErrInvalidOperand, key, err)
+		}
+		sum = n
+	}
+	for _, op := range operands {
+		n, err := DecodeUint64(op)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidOperand, key, err)
+		}
+		sum += n
+	}
+	return EncodeUint64(sum), nil
+}
+
+func (uint64Add) PartialMerge(key []byte, operands [][]byte) ([]byte, bool) {
+	var sum uint64
+	for _, op := range operands {
+		n, err := DecodeUint64(op)
+		if err != nil {
+			return nil, false
+		}
+		sum += n
+	}
+	return EncodeUint64(sum), true
+}
+
+// EncodeUint64 encodes n as Uint64Add expects it: 8 bytes, little-endian.
+func EncodeUint64(n uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, n)
+}
+
+// DecodeUint64 decodes a value written by EncodeUint64.
+func DecodeUint64(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("uint64 needs 8 bytes, got %d", len(b))
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// Append returns an operator appending each operand to the value, with sep
+// in between if the value isn't empty.
+func Append(sep []byte) Operator { return appendOp{sep: sep} }
+
+type appendOp struct {
+	sep []byte
+}
+
+func (a appendOp) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	out := append([]byte(nil), existing...)
+	for _, op := range operands {
+		if len(out) > 0 {
+			out = append(out, a.sep...)
+		}
+		out = append(out, op...)
+	}
+	return out, nil
+}
+
+func (a appendOp) PartialMerge(key []byte, operands [][]byte) ([]byte, bool) {
+	// An empty operand turns the separator before the next one on or off
+	// depending on what it follows, so only non-empty ones combine.
+	var out []byte
+	for i, op := range operands {
+		if len(op) == 0 {
+			return nil, false
+		}
+		if i > 0 {
+			out = append(out, a.sep...)
+		}
+		out = append(out, op...)
+	}
+	return out, true
+}
@@ -0,0 +1,123 @@
+// Package rangedel holds range tombstones. A tombstone written by
+// DeleteRange(start, end) at Seq hides every version of the keys in
+// [start, end) with a smaller Seq, wherever that version is stored.
+package rangedel
+
+import (
+	"bytes"
+	"cmp"
+	"slices"
+	"sort"
+)
+
+type Tombstone struct {
+	Start []byte // inclusive
+	End   []byte // exclusive
+	Seq   uint64
+}
+
+// Contains reports whether key is in [Start, End).
+func (t Tombstone) Contains(key []byte) bool {
+	return bytes.Compare(t.Start, key) <= 0 && bytes.Compare(key, t.End) < 0
+}
+
+// Overlaps reports whether [Start, End) intersects the closed key range
+// [smallest, largest].
+func (t Tombstone) Overlaps(smallest, largest []byte) bool {
+	return bytes.Compare(t.Start, largest) <= 0 && bytes.Compare(t.End, smallest) > 0
+}
+
+// List answers coverage queries over a set of tombstones. The tombstones are
+// cut at every start and end key into non-overlapping fragments, each
+// listing the sequence numbers of the tombstones over it, so a lookup is a
+// binary search. A nil *List is empty.
+type List struct {
+	tombs []Tombstone
+	frags []fragment // sorted by start
+}
+
+type fragment struct {
+	start, end []byte
+	seqs       []uint64 // descending
+}
+
+// NewList builds a List. It keeps ts; the caller must not modify it.
+func NewList(ts []Tombstone) *List {
+	if len(ts) == 0 {
+		return nil
+	}
+	var bounds [][]byte
+	for _, t := range ts {
+		bounds = append(bounds, t.Start, t.End)
+	}
+	slices.SortFunc(bounds, bytes.Compare)
+	bounds = slices.CompactFunc(bounds, bytes.Equal)
+
+	frags := make([]fragment, len(bounds)-1)
+	for i := range frags {
+		frags[i] = fragment{start: bounds[i], end: bounds[i+1]}
+	}
+	for _, t := range ts {
+		if bytes.Compare(t.Start, t.End) >= 0 {
+			continue
+		}
+		i, _ := slices.BinarySearchFunc(bounds, t.Start, bytes.Compare)
+		for ; i < len(frags) && bytes.Compare(frags[i].start, t.End) < 0; i++ {
+			frags[i].seqs = append(frags[i].seqs, t.Seq)
+		}
+	}
+	l := &List{tombs: ts}
+	for _, f := range frags {
+		if len(f.seqs) == 0 {
+			continue
+		}
+		slices.SortFunc(f.seqs, func(a, b uint64) int { return cmp.Compare(b, a) })
+		l.frags = append(l.frags, f)
+	}
+	return l
+}
+
+// Tombstones returns the tombstones the list was built from.
+func (l *List) Tombstones() []Tombstone {
+	if l == nil {
+		return nil
+	}
+	return l.tombs
+}
+
+// Len returns the number of tombstones.
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.tombs)
+}
+
+// MaxSeq returns the highest Seq <= seq of a tombstone covering key, or 0 if
+// none does. A version of key with a smaller Seq is deleted for a reader at
+// seq.
+func (l *List) MaxSeq(key []byte, seq uint64) uint64 {
+	if l == nil {
+		return 0
+	}
+	i := sort.Search(len(l.frags), func(i int) bool {
+		return bytes.Compare(l.frags[i].end, key) > 0
+	})
+	if i == len(l.frags) || bytes.Compare(l.frags[i].start, key) > 0 {
+		return 0
+	}
+	for _, s := range l.frags[i].seqs {
+		if s <= seq {
+			return s
+		}
+	}
+	return 0
+}
+
+// Bounds returns the smallest start and the largest end of the tombstones.
+func (l *List) Bounds() (smallest, largest []byte) {
+	if l == nil || len(l.frags) == 0 {
+		return nil, nil
+	}
+	return l.frags[0].start, l.frags[len(l.frags)-1].end
+}
@@ -0,0 +1,341 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	"github.com/ChinmayNoob/lsm-go/memtable"
+)
+
+// Block layout (v3):
+//
+//	entry*   [uvarint shared][uvarint unshared][uvarint valLen][u8 kind][uvarint seq][key suffix][val]
+//	restarts [u32 offset]*n
+//	         [u32 n]
+//
+// Each key is stored as the number of bytes it shares with the previous key
+// plus the rest. Every restartInterval entries the prefix is reset (shared=0)
+// and the entry offset is recorded as a restart point, so a lookup can binary
+// search the restart points and only decode a handful of entries.
+//
+// On disk every block is followed by a trailer [u8 blockType][u32 crc32c]
+// covering the stored (possibly compressed) block bytes and the type. The
+// type is the Compression the block was written with.
+const (
+	blockSize       = 4 << 10
+	blockTrailerLen = 1 + 4
+
+	blockTypeRaw byte = 0
+)
+
+const (
+	kindPut    byte = 0
+	kindDelete byte = 1
+	// kindRangeDelete marks the entries of the range-del block: the key is
+	// the start of the range and the value its end.
+	kindRangeDelete byte = 2
+	// kindPutTTL is a put with an expiry: the value is
+	// [u64 expiresAt][value].
+	kindPutTTL byte = 3
+	// kindMerge is a merge operand.
+	kindMerge byte = 4
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// blockHandle locates a block (without its trailer) in the file.
+type blockHandle struct {
+	offset uint64
+	size   uint64
+}
+
+func (h blockHandle) encode() []byte {
+	b := binary.AppendUvarint(nil, h.offset)
+	return binary.AppendUvarint(b, h.size)
+}
+
+func decodeHandle(b []byte) (blockHandle, error) {
+	off, n := binary.Uvarint(b)
+	if n <= 0 {
+		return blockHandle{}, ErrCorrupt
+	}
+	size, m := binary.Uvarint(b[n:])
+	if m <= 0 {
+		return blockHandle{}, ErrCorrupt
+	}
+	return blockHandle{offset: off, size: size}, nil
+}
+
+// blockChecksum is the CRC32C of a block followed by its type byte.
+func blockChecksum(b []byte, typ byte) uint32 {
+	crc := crc32.Checksum(b, crcTable)
+	return crc32.Update(crc, crcTable, []byte{typ})
+}
+
+// readBlock reads the block at h, verifies its trailer and decompresses it.
+func readBlock(f *os.File, h blockHandle) ([]byte, error) {
+	buf := make([]byte, h.size+blockTrailerLen)
+	if _, err := f.ReadAt(buf, int64(h.offset)); err != nil {
+		return nil, err
+	}
+	want := binary.LittleEndian.Uint32(buf[h.size+1:])
+	if blockChecksum(buf[:h.size], buf[h.size]) != want {
+		return nil, fmt.Errorf("%w: block at offset %d: checksum mismatch", ErrCorrupt, h.offset)
+	}
+	b, err := decompressBlock(buf[h.size], buf[:h.size])
+	if err != nil {
+		return nil, fmt.Errorf("block at offset %d: %w", h.offset, err)
+	}
+	return b, nil
+}
+
+type blockBuilder struct {
+	restartInterval int
+
+	buf      []byte
+	restarts []uint32
+	counter  int // entries since the last restart point
+	entries  int
+	lastKey  []byte
+	lastSeq  uint64
+}
+
+func newBlockBuilder(restartInterval int) *blockBuilder {
+	return &blockBuilder{
+		restartInterval: restartInterval,
+		restarts:        []uint32{0},
+	}
+}
+
+// add appends an entry. Entries must be added in internal order.
+func (b *blockBuilder) add(key []byte, kind byte, seq uint64, value []byte) {
+	shared := 0
+	if b.counter < b.restartInterval {
+		for shared < len(b.lastKey) && shared < len(key) && b.lastKey[shared] == key[shared] {
+			shared++
+		}
+	} else {
+		b.restarts = append(b.restarts, uint32(len(b.buf)))
+		b.counter = 0
+	}
+	b.buf = binary.AppendUvarint(b.buf, uint64(shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(key)-shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(value)))
+	b.buf = append(b.buf, kind)
+	b.buf = binary.AppendUvarint(b.buf, seq)
+	b.buf = append(b.buf, key[shared:]...)
+	b.buf = append(b.buf, value...)
+
+	b.lastKey = append(b.lastKey[:0], key...)
+	b.lastSeq = seq
+	b.counter++
+	b.entries++
+}
+
+func (b *blockBuilder) empty() bool { return b.entries == 0 }
+
+func (b *blockBuilder) estimatedSize() int {
+	return len(b.buf) + 4*len(b.restarts) + 4
+}
+
+// finish appends the restart array and returns the block. The result is only
+// valid until reset.
+func (b *blockBuilder) finish() []byte {
+	for _, r := range b.restarts {
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, r)
+	}
+	return binary.LittleEndian.AppendUint32(b.buf, uint32(len(b.restarts)))
+}
+
+func (b *blockBuilder) reset() {
+	b.buf = b.buf[:0]
+	b.restarts = b.restarts[:1]
+	b.counter = 0
+	b.entries = 0
+	b.lastKey = b.lastKey[:0]
+	b.lastSeq = 0
+}
+
+// blockIter walks the entries of one block. It is invalid once it moves past
+// either end.
+type blockIter struct {
+	data        []byte // entries, without the restart array
+	restarts    []byte
+	numRestarts int
+
+	off        int // offset of the current entry; len(data) when invalid
+	nextOff    int
+	restartIdx int // restart point at or before off
+
+	key   []byte
+	kind  byte
+	seq   uint64
+	value []byte
+	err   error
+}
+
+func newBlockIter(b []byte) (*blockIter, error) {
+	if len(b) < 4 {
+		return nil, ErrCorrupt
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-4:]))
+	if n == 0 || 4*(n+1) > len(b) {
+		return nil, ErrCorrupt
+	}
+	restartsOff := len(b) - 4*(n+1)
+	it := &blockIter{
+		data:        b[:restartsOff],
+		restarts:    b[restartsOff : len(b)-4],
+		numRestarts: n,
+	}
+	it.invalidate()
+	return it, nil
+}
+
+func (it *blockIter) restartPoint(i int) int {
+	return int(binary.LittleEndian.Uint32(it.restarts[4*i:]))
+}
+
+func (it *blockIter) invalidate() {
+	it.off = len(it.data)
+	it.nextOff = len(it.data)
+	it.restartIdx = it.numRestarts
+}
+
+func (it *blockIter) seekToRestart(i int) {
+	it.key = it.key[:0]
+	it.restartIdx = i
+	it.nextOff = it.restartPoint(i)
+}
+
+// parseNext decodes the entry at nextOff and makes it current.
+func (it *blockIter) parseNext() bool {
+	it.off = it.nextOff
+	if it.off >= len(it.data) {
+		it.invalidate()
+		return false
+	}
+	p := it.data[it.off:]
+	shared, ok1 := readUvarint(&p)
+	unshared, ok2 := readUvarint(&p)
+	valLen, ok3 := readUvarint(&p)
+	if !ok1 || !ok2 || !ok3 || len(p) < 1 {
+		return it.corrupt()
+	}
+	kind := p[0]
+	p = p[1:]
+	seq, ok := readUvarint(&p)
+	if !ok {
+		return it.corrupt()
+	}
+	if shared > uint64(len(it.key)) || unshared > uint64(len(p)) || valLen > uint64(len(p))-unshared {
+		return it.corrupt()
+	}
+	if kind == kindPutTTL && valLen < 8 {
+		return it.corrupt()
+	}
+	it.key = append(it.key[:shared], p[:unshared]...)
+	it.value = p[unshared : unshared+valLen]
+	it.kind = kind
+	it.seq = seq
+	it.nextOff = len(it.data) - len(p) + int(unshared+valLen)
+	for it.restartIdx+1 < it.numRestarts && it.restartPoint(it.restartIdx+1) <= it.off {
+		it.restartIdx++
+	}
+	return true
+}
+
+// readUvarint decodes a uvarint from the front of *p and advances it.
+func readUvarint(p *[]byte) (uint64, bool) {
+	v, n := binary.Uvarint(*p)
+	if n <= 0 {
+		return 0, false
+	}
+	*p = (*p)[n:]
+	return v, true
+}
+
+func (it *blockIter) corrupt() bool {
+	it.err = fmt.Errorf("%w: bad block entry at %d", ErrCorrupt, it.off)
+	it.invalidate()
+	return false
+}
+
+func (it *blockIter) First() {
+	it.seekToRestart(0)
+	it.parseNext()
+}
+
+func (it *blockIter) Last() {
+	it.seekToRestart(it.numRestarts - 1)
+	for it.parseNext() && it.nextOff < len(it.data) {
+	}
+}
+
+// SeekGE moves to the first entry whose key is >= key.
+func (it *blockIter) SeekGE(key []byte) {
+	// Find the last restart point whose key is < key.
+	lo, hi := 0, it.numRestarts-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		it.seekToRestart(mid)
+		if !it.parseNext() {
+			return
+		}
+		if bytes.Compare(it.key, key) < 0 {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	it.seekToRestart(lo)
+	for it.parseNext() && bytes.Compare(it.key, key) < 0 {
+	}
+}
+
+func (it *blockIter) Next() {
+	if it.Valid() {
+		it.parseNext()
+	}
+}
+
+// Prev rescans from the restart point before the current entry, since
+// entries can only be decoded forward.
+func (it *blockIter) Prev() {
+	if !it.Valid() {
+		return
+	}
+	orig := it.off
+	for it.restartPoint(it.restartIdx) >= orig {
+		if it.restartIdx == 0 {
+			it.invalidate()
+			return
+		}
+		it.restartIdx--
+	}
+	it.seekToRestart(it.restartIdx)
+	for it.parseNext() && it.nextOff < orig {
+	}
+}
+
+func (it *blockIter) Valid() bool { return it.err == nil && it.off < len(it.data) }
+
+func (it *blockIter) Record() memtable.Record {
+	r := memtable.Record{
+		Key:       it.key,
+		Value:     it.value,
+		Tombstone: it.kind == kindDelete,
+		Merge:     it.kind == kindMerge,
+		Seq:       it.seq,
+	}
+	if it.kind == kindPutTTL {
+		r.ExpiresAt = int64(binary.LittleEndian.Uint64(r.Value))
+		r.Value = r.Value[8:]
+	}
+	return r
+}
+
+func (it *blockIter) Err() error { return it.err }
@@ -0,0 +1,97 @@
+package sstable
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+
+	"github.com/ChinmayNoob/lsm-go/lz"
+)
+
+// Compression selects the codec for the blocks of new tables. It is stored as
+// the type byte of each block trailer, so every block says how to read
+// itself and tables written with different codecs can live side by side.
+type Compression byte
+
+const (
+	NoCompression    Compression = Compression(blockTypeRaw)
+	FlateCompression Compression = 1
+	LZCompression    Compression = 2
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case FlateCompression:
+		return "flate"
+	case LZCompression:
+		return "lz"
+	default:
+		return fmt.Sprintf("Compression(%d)", byte(c))
+	}
+}
+
+// ParseCompression maps a codec name ("none", "flate", "lz") to its value.
+func ParseCompression(s string) (Compression, error) {
+	for _, c := range []Compression{NoCompression, FlateCompression, LZCompression} {
+		if s == c.String() {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("sstable: unknown compression %q", s)
+}
+
+// compressBlock encodes b with c. Blocks that don't shrink by at least 1/8
+// are kept raw, as decompressing them would cost more than it saves.
+func compressBlock(c Compression, b []byte) ([]byte, byte, error) {
+	var out []byte
+	switch c {
+	case NoCompression:
+		return b, blockTypeRaw, nil
+	case FlateCompression:
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, flate.BestSpeed)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := fw.Write(b); err != nil {
+			return nil, 0, err
+		}
+		if err := fw.Close(); err != nil {
+			return nil, 0, err
+		}
+		out = buf.Bytes()
+	case LZCompression:
+		out = lz.Encode(nil, b)
+	default:
+		return nil, 0, fmt.Errorf("sstable: unknown compression %d", byte(c))
+	}
+	if len(out) >= len(b)-len(b)/8 {
+		return b, blockTypeRaw, nil
+	}
+	return out, byte(c), nil
+}
+
+// decompressBlock decodes a block stored with the given trailer type.
+func decompressBlock(typ byte, b []byte) ([]byte, error) {
+	switch Compression(typ) {
+	case NoCompression:
+		return b, nil
+	case FlateCompression:
+		out, err := io.ReadAll(flate.NewReader(bytes.NewReader(b)))
+		if err != nil {
+			return nil, fmt.Errorf("%w: flate: %v", ErrCorrupt, err)
+		}
+		return out, nil
+	case LZCompression:
+		out, err := lz.Decode(nil, b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown block type %d", ErrCorrupt, typ)
+	}
+}
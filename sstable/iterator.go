@@ -0,0 +1,180 @@
+package sstable
+
+import (
+	"os"
+
+	"github.com/ChinmayNoob/lsm-go/memtable"
+)
+
+// cursor walks the entries of one block (or legacy segment).
+type cursor interface {
+	First()
+	Last()
+	SeekGE(key []byte)
+	Next()
+	Prev()
+	Valid() bool
+	Record() memtable.Record
+	Err() error
+}
+
+// Iterator walks the entries of a Table in internal order, one block at a
+// time. It holds on to the table's file until Close, so an iterator stays
+// usable after compaction has removed the table's file.
+type Iterator struct {
+	t       *Table
+	f       *os.File // acquired on the first block cache miss if nil
+	release func()
+	closed  bool
+	blk     int
+	cur     cursor
+	err     error
+}
+
+// NewIterator returns an unpositioned iterator over every entry of the
+// table, including tombstones and older versions. Call Close when done.
+func (t *Table) NewIterator() (*Iterator, error) {
+	f, release, err := t.acquire()
+	if err != nil {
+		return nil, err
+	}
+	return &Iterator{t: t, f: f, release: release, blk: -1}, nil
+}
+
+// First moves to the first entry.
+func (it *Iterator) First() {
+	it.loadForward(0)
+}
+
+// Last moves to the last entry.
+func (it *Iterator) Last() {
+	it.loadBackward(len(it.t.index) - 1)
+}
+
+// SeekGE moves to the first entry whose key is >= key.
+func (it *Iterator) SeekGE(key []byte) {
+	if !it.load(it.t.blockFor(key)) {
+		return
+	}
+	it.cur.SeekGE(key)
+	if it.settle() && !it.cur.Valid() {
+		it.loadForward(it.blk + 1)
+	}
+}
+
+// Next moves to the next entry; it is a no-op on an invalid iterator.
+func (it *Iterator) Next() {
+	if !it.Valid() {
+		return
+	}
+	it.cur.Next()
+	if it.settle() && !it.cur.Valid() {
+		it.loadForward(it.blk + 1)
+	}
+}
+
+// Prev moves to the previous entry; it is a no-op on an invalid iterator.
+func (it *Iterator) Prev() {
+	if !it.Valid() {
+		return
+	}
+	it.cur.Prev()
+	if it.settle() && !it.cur.Valid() {
+		it.loadBackward(it.blk - 1)
+	}
+}
+
+// Valid reports whether the iterator is at an entry. Check Err once it
+// isn't.
+func (it *Iterator) Valid() bool {
+	return it.err == nil && it.cur != nil && it.cur.Valid()
+}
+
+// Record returns the current entry. The slices are only valid until the
+// iterator moves.
+func (it *Iterator) Record() memtable.Record {
+	r := it.cur.Record()
+	if it.t.globalSeq != 0 {
+		r.Seq = it.t.globalSeq
+	}
+	return r
+}
+
+// Err returns the first read or decoding error.
+func (it *Iterator) Err() error { return it.err }
+
+// Close releases the table's file.
+func (it *Iterator) Close() error {
+	it.closed = true
+	it.cur = nil
+	if it.f != nil {
+		it.release()
+		it.f = nil
+	}
+	return nil
+}
+
+func (it *Iterator) file() (*os.File, error) {
+	if it.f == nil {
+		f, release, err := it.t.acquire()
+		if err != nil {
+			return nil, err
+		}
+		it.f, it.release = f, release
+	}
+	return it.f, nil
+}
+
+// settle picks up a decoding error from the block cursor and reports whether
+// iteration can go on.
+func (it *Iterator) settle() bool {
+	if err := it.cur.Err(); err != nil {
+		it.err = err
+		it.cur = nil
+		return false
+	}
+	return true
+}
+
+// loadForward positions at the first entry of the first non-empty block at
+// or after blk.
+func (it *Iterator) loadForward(blk int) {
+	for ; it.load(blk); blk++ {
+		it.cur.First()
+		if !it.settle() || it.cur.Valid() {
+			return
+		}
+	}
+}
+
+// loadBackward positions at the last entry of the last non-empty block at or
+// before blk.
+func (it *Iterator) loadBackward(blk int) {
+	for ; it.load(blk); blk-- {
+		it.cur.Last()
+		if !it.settle() || it.cur.Valid() {
+			return
+		}
+	}
+}
+
+// load reads block blk and reports whether it exists. The position is left
+// unset until the caller moves the cursor.
+func (it *Iterator) load(blk int) bool {
+	if it.closed || it.err != nil || blk < 0 || blk >= len(it.t.index) {
+		it.cur = nil
+		return false
+	}
+	if blk == it.blk && it.cur != nil {
+		return true
+	}
+	cur, err := it.t.readCursor(it.file, blk)
+	if err != nil {
+		it.err = err
+		it.cur = nil
+		return false
+	}
+	it.blk = blk
+	it.cur = cur
+	return true
+}
@@ -0,0 +1,237 @@
+package sstable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/ChinmayNoob/lsm-go/bloom"
+	"github.com/ChinmayNoob/lsm-go/memtable"
+)
+
+// v1/v2 tables are a flat run of entries
+//
+//	[u32 keyLen][key][u8 tomb][u32 valLen][val][u64 seq]
+//
+// followed by the Bloom section (v2 only), a sparse index and the footer. They
+// are read-only now: Writer always writes v3.
+
+// openLegacy loads the Bloom filter of a v1/v2 table and cuts its entries
+// into segments of legacyIndexEvery records that the iterator reads like
+// blocks.
+func openLegacy(f *os.File, size int64, t *Table) error {
+	// v1 footer (14 bytes): [u64 indexOffset][u32 magic][u16 version]
+	footer := make([]byte, 8+4+2)
+	if _, err := f.ReadAt(footer, size-int64(len(footer))); err != nil {
+		return err
+	}
+
+	var (
+		idxOff   uint64
+		bloomOff uint64
+		bloomLen uint64
+	)
+	switch t.version {
+	case version:
+		idxOff = binary.LittleEndian.Uint64(footer[0:8])
+	case versionBloom:
+		// Footer v2 layout (30 bytes):
+		// [u64 indexOffset][u64 bloomOffset][u64 bloomLen][u32 magic][u16 version]
+		v2Size := int64(8 + 8 + 8 + 4 + 2)
+		if size < v2Size {
+			return ErrCorrupt
+		}
+		v2 := make([]byte, v2Size)
+		if _, err := f.ReadAt(v2, size-v2Size); err != nil {
+			return err
+		}
+		idxOff = binary.LittleEndian.Uint64(v2[0:8])
+		bloomOff = binary.LittleEndian.Uint64(v2[8:16])
+		bloomLen = binary.LittleEndian.Uint64(v2[16:24])
+	}
+
+	if idxOff >= uint64(size) {
+		return ErrCorrupt
+	}
+	dataEnd := idxOff
+
+	if bloomLen > 0 {
+		if bloomOff >= uint64(size) || bloomOff+bloomLen > uint64(size) {
+			return ErrCorrupt
+		}
+		bb := make([]byte, bloomLen)
+		if _, err := f.ReadAt(bb, int64(bloomOff)); err != nil {
+			return err
+		}
+		bf, ok := bloom.Decode(bb)
+		if !ok {
+			return ErrCorrupt
+		}
+		t.bf = bf
+		// v2 writes the Bloom section between the entries and the index.
+		if bloomOff < dataEnd {
+			dataEnd = bloomOff
+		}
+	}
+
+	// Builders before v3 recorded index offsets while the entries were still
+	// sitting in a bufio buffer, so the on-disk index can't be trusted.
+	// Rebuild it with one sequential pass over the entries instead.
+	index, maxSeq, err := scanIndex(f, dataEnd, legacyIndexEvery)
+	if err != nil {
+		return err
+	}
+	t.index = index
+	t.maxSeq = maxSeq
+	return nil
+}
+
+const legacyIndexEvery = 16
+
+// scanIndex reads the entries in [0, dataEnd) and returns a sparse index with
+// one entry (keyed by its first key) every n records, along with the highest
+// Seq seen.
+func scanIndex(f *os.File, dataEnd uint64, n int) ([]indexEntry, uint64, error) {
+	r := bufio.NewReaderSize(io.NewSectionReader(f, 0, int64(dataEnd)), 64*1024)
+	var (
+		index  []indexEntry
+		off    uint64
+		maxSeq uint64
+	)
+	for i := 0; ; i++ {
+		rec, ok, err := readEntry(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			break
+		}
+		if i%n == 0 {
+			if len(index) > 0 {
+				prev := &index[len(index)-1]
+				prev.handle.size = off - prev.handle.offset
+			}
+			index = append(index, indexEntry{key: rec.Key, handle: blockHandle{offset: off}})
+		}
+		if rec.Seq > maxSeq {
+			maxSeq = rec.Seq
+		}
+		off += entrySize(rec)
+	}
+	if off != dataEnd {
+		return nil, 0, ErrCorrupt
+	}
+	if len(index) > 0 {
+		last := &index[len(index)-1]
+		last.handle.size = off - last.handle.offset
+	}
+	return index, maxSeq, nil
+}
+
+func entrySize(r memtable.Record) uint64 {
+	return uint64(4 + len(r.Key) + 1 + 4 + len(r.Value) + 8)
+}
+
+// readLegacySegment reads and decodes the entries of one index segment.
+func readLegacySegment(f *os.File, h blockHandle) ([]memtable.Record, error) {
+	buf := make([]byte, h.size)
+	if _, err := f.ReadAt(buf, int64(h.offset)); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(bytes.NewReader(buf))
+	var recs []memtable.Record
+	for {
+		rec, ok, err := readEntry(r)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return recs, nil
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func readEntry(r *bufio.Reader) (memtable.Record, bool, error) {
+	var klenBuf [4]byte
+	_, err := io.ReadFull(r, klenBuf[:])
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return memtable.Record{}, false, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return memtable.Record{}, false, ErrCorrupt
+		}
+		return memtable.Record{}, false, err
+	}
+	klen := binary.LittleEndian.Uint32(klenBuf[:])
+	if klen == 0 {
+		return memtable.Record{}, false, ErrCorrupt
+	}
+	k := make([]byte, klen)
+	if _, err := io.ReadFull(r, k); err != nil {
+		return memtable.Record{}, false, ErrCorrupt
+	}
+	tomb, err := r.ReadByte()
+	if err != nil {
+		return memtable.Record{}, false, ErrCorrupt
+	}
+	var vlenBuf [4]byte
+	if _, err := io.ReadFull(r, vlenBuf[:]); err != nil {
+		return memtable.Record{}, false, ErrCorrupt
+	}
+	vlen := binary.LittleEndian.Uint32(vlenBuf[:])
+	v := make([]byte, vlen)
+	if _, err := io.ReadFull(r, v); err != nil {
+		return memtable.Record{}, false, ErrCorrupt
+	}
+	var seqBuf [8]byte
+	if _, err := io.ReadFull(r, seqBuf[:]); err != nil {
+		return memtable.Record{}, false, ErrCorrupt
+	}
+	seq := binary.LittleEndian.Uint64(seqBuf[:])
+	return memtable.Record{
+		Key:       k,
+		Value:     v,
+		Tombstone: tomb == 1,
+		Seq:       seq,
+	}, true, nil
+}
+
+// sliceIter walks the decoded records of a legacy segment.
+type sliceIter struct {
+	recs []memtable.Record
+	pos  int
+}
+
+func (it *sliceIter) First() { it.pos = 0 }
+
+func (it *sliceIter) Last() { it.pos = len(it.recs) - 1 }
+
+func (it *sliceIter) SeekGE(key []byte) {
+	it.pos = sort.Search(len(it.recs), func(i int) bool {
+		return bytes.Compare(it.recs[i].Key, key) >= 0
+	})
+}
+
+func (it *sliceIter) Next() {
+	if it.Valid() {
+		it.pos++
+	}
+}
+
+func (it *sliceIter) Prev() {
+	if it.Valid() {
+		it.pos--
+	}
+}
+
+func (it *sliceIter) Valid() bool { return it.pos >= 0 && it.pos < len(it.recs) }
+
+func (it *sliceIter) Record() memtable.Record { return it.recs[it.pos] }
+
+func (it *sliceIter) Err() error { return nil }
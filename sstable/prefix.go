@@ -0,0 +1,61 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// PrefixExtractor picks the part of a key that prefix scans ask about, like
+// "tenant/entity/" in "tenant/entity/id". A Writer given one adds each
+// key's prefix to the table's Bloom filter, so a scan for a prefix can skip
+// tables that hold no key with it.
+type PrefixExtractor interface {
+	// Name identifies the extractor. It is stored in every table written
+	// with it; a table written with another one is never skipped.
+	Name() string
+	// Prefix returns the prefix of key, or false if key has none. If it
+	// returns p for some key, it must return p for every key starting
+	// with p.
+	Prefix(key []byte) ([]byte, bool)
+}
+
+// FixedPrefix returns an extractor taking the first n bytes of keys that
+// are at least n bytes long.
+func FixedPrefix(n int) PrefixExtractor { return fixedPrefix(n) }
+
+type fixedPrefix int
+
+func (n fixedPrefix) Name() string { return fmt.Sprintf("fixed:%d", int(n)) }
+
+func (n fixedPrefix) Prefix(key []byte) ([]byte, bool) {
+	if len(key) < int(n) {
+		return nil, false
+	}
+	return key[:n], true
+}
+
+// SeparatorPrefix returns an extractor taking everything up to and including
+// the n-th sep of keys that have that many: SeparatorPrefix('/', 2) maps
+// "tenant/entity/id" to "tenant/entity/".
+func SeparatorPrefix(sep byte, n int) PrefixExtractor {
+	return separatorPrefix{sep: sep, n: n}
+}
+
+type separatorPrefix struct {
+	sep byte
+	n   int
+}
+
+func (s separatorPrefix) Name() string { return fmt.Sprintf("separator:%q:%d", s.sep, s.n) }
+
+func (s separatorPrefix) Prefix(key []byte) ([]byte, bool) {
+	end := 0
+	for range s.n {
+		i := bytes.IndexByte(key[end:], s.sep)
+		if i < 0 {
+			return nil, false
+		}
+		end += i + 1
+	}
+	return key[:end], true
+}
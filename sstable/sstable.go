@@ -1,29 +1,54 @@
 package sstable
 
 import (
-	"bufio"
 	"bytes"
+	"cmp"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/ChinmayNoob/lsm-go/bloom"
+	"github.com/ChinmayNoob/lsm-go/cache"
 	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/rangedel"
 )
 
 const (
 	magic        uint32 = 0x4c534d31
 	version      uint16 = 1
 	versionBloom uint16 = 2
+	versionBlock uint16 = 3
 )
 
+// v3 layout:
+//
+//	[data block][trailer] ...
+//	[filter block][trailer]
+//	[meta block][trailer]
+//	[index block][trailer]
+//	footer (38 bytes): [u64 metaOffset][u64 metaSize][u64 indexOffset][u64 indexSize][u32 magic][u16 version]
+//
+// The index block has one entry per data block, keyed by the block's last
+// key and Seq, whose value is the block handle. The meta block maps names to
+// table-level metadata: "filter" is the handle of the Bloom filter block,
+// "max-seq" the highest Seq in the table and, if the table has range
+// tombstones, "range-del" the handle of a block holding them, sorted by start.
+// If the filter also holds key prefixes, "prefix-extractor" names the
+// extractor. Readers that predate "range-del" or "prefix-extractor" ignore
+// them.
+const footerSizeV3 = 8 + 8 + 8 + 8 + 4 + 2
+
 var ErrCorrupt = errors.New("sstable: corrupt")
 
+// indexEntry points at one data block (v3) or one run of entries (v1/v2).
+// For v3 key is the block's last key, for v1/v2 its first.
 type indexEntry struct {
 	key    []byte
-	offset uint64
+	handle blockHandle
 }
 
 type Table struct {
@@ -31,20 +56,50 @@ type Table struct {
 	ID    uint64
 	index []indexEntry
 
-	indexOffset uint64
+	version   uint16
+	bf        *bloom.Filter
+	prefixes  string         // name of the extractor whose prefixes bf holds
+	rangeDels *rangedel.List // nil if none
+	cache     *cache.Cache   // may be nil
+	files     *cache.Files   // may be nil
+
+	maxSeq    uint64
+	globalSeq uint64 // 0 unless ingested
+	size      int64
+	smallest  []byte
+	largest   []byte
+}
+
+// ReaderOptions are the caches a Table reads through. Either may be nil.
+type ReaderOptions struct {
+	// BlockCache holds decoded data blocks keyed by table ID and offset.
+	BlockCache *cache.Cache
+	// Files keeps the table's file open between reads.
+	Files *cache.Files
+}
 
-	bloomOffset uint64
-	bloomLen    uint64
-	bf          *bloom.Filter
+// Open opens an existing SSTable and loads its index and Bloom filter. Tables
+// written in the older flat formats (v1/v2) are still readable.
+func Open(path string, id uint64, ro ReaderOptions) (*Table, error) {
+	return OpenIngested(path, id, 0, ro)
 }
 
-// Open opens an existing SSTable and loads its sparse index.
-func Open(path string, id uint64) (*Table, error) {
-	f, err := os.Open(path)
+// OpenIngested opens a table that was built outside the DB and ingested at
+// globalSeq: every entry and range tombstone reads with that sequence number,
+// whatever the file says. A globalSeq of 0 is the same as Open.
+func OpenIngested(path string, id, globalSeq uint64, ro ReaderOptions) (*Table, error) {
+	t := &Table{
+		Path:      path,
+		ID:        id,
+		globalSeq: globalSeq,
+		cache:     ro.BlockCache,
+		files:     ro.Files,
+	}
+	f, release, err := t.acquire()
 	if err != nil {
 		return nil, err
 	}
-	defer func() { _ = f.Close() }()
+	defer release()
 
 	st, err := f.Stat()
 	if err != nil {
@@ -54,291 +109,220 @@ func Open(path string, id uint64) (*Table, error) {
 		return nil, ErrCorrupt
 	}
 
-	// Peek v1 footer first to get version.
-	v1FooterSize := int64(8 + 4 + 2)
-	footer := make([]byte, v1FooterSize)
-	if _, err := f.ReadAt(footer, st.Size()-v1FooterSize); err != nil {
+	// Every version ends in [u32 magic][u16 version].
+	var tail [4 + 2]byte
+	if _, err := f.ReadAt(tail[:], st.Size()-int64(len(tail))); err != nil {
 		return nil, err
 	}
-	gotMagic := binary.LittleEndian.Uint32(footer[8:12])
-	gotVer := binary.LittleEndian.Uint16(footer[12:14])
-	if gotMagic != magic {
+	if binary.LittleEndian.Uint32(tail[0:4]) != magic {
 		return nil, ErrCorrupt
 	}
 
-	var (
-		idxOff     uint64
-		bloomOff   uint64
-		bloomLen   uint64
-		footerSize uint64
-	)
-	switch gotVer {
-	case version:
-		idxOff = binary.LittleEndian.Uint64(footer[0:8])
-		footerSize = uint64(v1FooterSize)
-	case versionBloom:
-		// Footer v2 layout (30 bytes):
-		// [u64 indexOffset][u64 bloomOffset][u64 bloomLen][u32 magic][u16 version]
-		v2Size := int64(8 + 8 + 8 + 4 + 2)
-		if st.Size() < v2Size {
-			return nil, ErrCorrupt
-		}
-		v2 := make([]byte, v2Size)
-		if _, err := f.ReadAt(v2, st.Size()-v2Size); err != nil {
-			return nil, err
-		}
-		idxOff = binary.LittleEndian.Uint64(v2[0:8])
-		bloomOff = binary.LittleEndian.Uint64(v2[8:16])
-		bloomLen = binary.LittleEndian.Uint64(v2[16:24])
-		gotMagic2 := binary.LittleEndian.Uint32(v2[24:28])
-		gotVer2 := binary.LittleEndian.Uint16(v2[28:30])
-		if gotMagic2 != magic || gotVer2 != versionBloom {
-			return nil, ErrCorrupt
-		}
-		footerSize = uint64(v2Size)
+	t.version = binary.LittleEndian.Uint16(tail[4:6])
+	switch t.version {
+	case version, versionBloom:
+		err = openLegacy(f, st.Size(), t)
+	case versionBlock:
+		err = t.openBlocks(f, st.Size())
 	default:
-		return nil, ErrCorrupt
-	}
-
-	if idxOff >= uint64(st.Size()) {
-		return nil, ErrCorrupt
+		err = ErrCorrupt
 	}
-
-	// Index format: repeated [u32 keyLen][key][u64 offset] until footer starts.
-	indexBytesLen := uint64(st.Size()) - footerSize - idxOff
-	if _, err := f.Seek(int64(idxOff), io.SeekStart); err != nil {
+	if err != nil {
 		return nil, err
 	}
-	r := bufio.NewReaderSize(f, 64*1024)
-	limited := io.LimitReader(r, int64(indexBytesLen))
-
-	var entries []indexEntry
-	for {
-		var klenBuf [4]byte
-		_, err := io.ReadFull(limited, klenBuf[:])
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			if errors.Is(err, io.ErrUnexpectedEOF) {
-				return nil, ErrCorrupt
-			}
-			return nil, err
-		}
-		klen := binary.LittleEndian.Uint32(klenBuf[:])
-		if klen == 0 {
-			return nil, ErrCorrupt
-		}
-		k := make([]byte, klen)
-		if _, err := io.ReadFull(limited, k); err != nil {
-			return nil, ErrCorrupt
-		}
-		var offBuf [8]byte
-		if _, err := io.ReadFull(limited, offBuf[:]); err != nil {
-			return nil, ErrCorrupt
-		}
-		off := binary.LittleEndian.Uint64(offBuf[:])
-		entries = append(entries, indexEntry{key: k, offset: off})
+	t.size = st.Size()
+	if t.globalSeq != 0 {
+		t.maxSeq = t.globalSeq
 	}
 
-	t := &Table{
-		Path:        path,
-		ID:          id,
-		index:       entries,
-		indexOffset: idxOff,
-		bloomOffset: bloomOff,
-		bloomLen:    bloomLen,
+	// Key range, from the first and last entry.
+	it := &Iterator{t: t, f: f, release: func() {}, blk: -1}
+	if it.First(); it.Valid() {
+		t.smallest = cloneBytes(it.Record().Key)
 	}
-
-	if bloomLen > 0 {
-		if bloomOff >= uint64(st.Size()) || bloomOff+bloomLen > uint64(st.Size()) {
-			return nil, ErrCorrupt
-		}
-		bb := make([]byte, bloomLen)
-		if _, err := f.ReadAt(bb, int64(bloomOff)); err != nil {
-			return nil, err
+	if it.Last(); it.Valid() {
+		t.largest = cloneBytes(it.Record().Key)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	// Range tombstones widen it, so the table covers every key they delete.
+	if start, end := t.rangeDels.Bounds(); start != nil {
+		if t.smallest == nil || bytes.Compare(start, t.smallest) < 0 {
+			t.smallest = start
 		}
-		bf, ok := bloom.Decode(bb)
-		if !ok {
-			return nil, ErrCorrupt
+		if t.largest == nil || bytes.Compare(end, t.largest) > 0 {
+			t.largest = end
 		}
-		t.bf = bf
 	}
-
 	return t, nil
 }
 
-// Build writes a new SSTable at path from the given memtable.
-// keys must be sorted (ascending).
-func Build(path string, keys [][]byte, mt *memtable.Memtable, indexEveryN int) error {
-	if indexEveryN <= 0 {
-		indexEveryN = 16
+func (t *Table) openBlocks(f *os.File, size int64) error {
+	if size < footerSizeV3 {
+		return ErrCorrupt
 	}
-
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
-	if err != nil {
+	footer := make([]byte, footerSizeV3)
+	if _, err := f.ReadAt(footer, size-footerSizeV3); err != nil {
 		return err
 	}
-	defer func() { _ = f.Close() }()
-
-	w := bufio.NewWriterSize(f, 64*1024)
-
-	var index []indexEntry
-	bf := bloom.NewForKeys(len(keys), 10, 7)
-	for i, k := range keys {
-		r, ok := mt.Get(k)
-		if !ok {
-			continue
-		}
-		off, err := f.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return err
-		}
-		if i%indexEveryN == 0 {
-			index = append(index, indexEntry{key: cloneBytes(k), offset: uint64(off)})
-		}
-		bf.Add(k)
-		if err := writeEntry(w, r); err != nil {
-			return err
-		}
+	metaH := blockHandle{
+		offset: binary.LittleEndian.Uint64(footer[0:8]),
+		size:   binary.LittleEndian.Uint64(footer[8:16]),
 	}
-	if err := w.Flush(); err != nil {
-		return err
+	indexH := blockHandle{
+		offset: binary.LittleEndian.Uint64(footer[16:24]),
+		size:   binary.LittleEndian.Uint64(footer[24:32]),
+	}
+	for _, h := range []blockHandle{metaH, indexH} {
+		if h.offset+h.size+blockTrailerLen > uint64(size) {
+			return ErrCorrupt
+		}
 	}
 
-	// Bloom section.
-	bloomOff, err := f.Seek(0, io.SeekCurrent)
+	meta, err := readBlock(f, metaH)
 	if err != nil {
 		return err
 	}
-	bloomBytes := bf.Encode()
-	if _, err := w.Write(bloomBytes); err != nil {
-		return err
-	}
-	if err := w.Flush(); err != nil {
-		return err
-	}
-
-	idxOff, err := f.Seek(0, io.SeekCurrent)
+	mi, err := newBlockIter(meta)
 	if err != nil {
 		return err
 	}
-	// Write index.
-	for _, e := range index {
-		if err := writeIndexEntry(w, e); err != nil {
-			return err
+	for mi.First(); mi.Valid(); mi.Next() {
+		switch string(mi.key) {
+		case "filter":
+			h, err := decodeHandle(mi.value)
+			if err != nil {
+				return err
+			}
+			fb, err := readBlock(f, h)
+			if err != nil {
+				return err
+			}
+			bf, ok := bloom.Decode(fb)
+			if !ok {
+				return ErrCorrupt
+			}
+			t.bf = bf
+		case "max-seq":
+			v, n := binary.Uvarint(mi.value)
+			if n <= 0 {
+				return ErrCorrupt
+			}
+			t.maxSeq = v
+		case "prefix-extractor":
+			t.prefixes = string(mi.value)
+		case "range-del":
+			h, err := decodeHandle(mi.value)
+			if err != nil {
+				return err
+			}
+			if err := t.loadRangeDels(f, h); err != nil {
+				return err
+			}
 		}
 	}
-	// Footer.
-	// Footer v2 layout (30 bytes):
-	// [u64 indexOffset][u64 bloomOffset][u64 bloomLen][u32 magic][u16 version]
-	var footer [8 + 8 + 8 + 4 + 2]byte
-	binary.LittleEndian.PutUint64(footer[0:8], uint64(idxOff))
-	binary.LittleEndian.PutUint64(footer[8:16], uint64(bloomOff))
-	binary.LittleEndian.PutUint64(footer[16:24], uint64(len(bloomBytes)))
-	binary.LittleEndian.PutUint32(footer[24:28], magic)
-	binary.LittleEndian.PutUint16(footer[28:30], versionBloom)
-	if _, err := w.Write(footer[:]); err != nil {
+	if err := mi.Err(); err != nil {
 		return err
 	}
-	if err := w.Flush(); err != nil {
-		return err
-	}
-	return f.Sync()
-}
 
-func writeIndexEntry(w *bufio.Writer, e indexEntry) error {
-	var klenBuf [4]byte
-	binary.LittleEndian.PutUint32(klenBuf[:], uint32(len(e.key)))
-	if _, err := w.Write(klenBuf[:]); err != nil {
+	index, err := readBlock(f, indexH)
+	if err != nil {
 		return err
 	}
-	if _, err := w.Write(e.key); err != nil {
+	ii, err := newBlockIter(index)
+	if err != nil {
 		return err
 	}
-	var offBuf [8]byte
-	binary.LittleEndian.PutUint64(offBuf[:], e.offset)
-	if _, err := w.Write(offBuf[:]); err != nil {
-		return err
+	for ii.First(); ii.Valid(); ii.Next() {
+		h, err := decodeHandle(ii.value)
+		if err != nil {
+			return err
+		}
+		t.index = append(t.index, indexEntry{key: cloneBytes(ii.key), handle: h})
 	}
-	return nil
+	return ii.Err()
 }
 
-// Entry format:
-// [u32 keyLen][key][u8 tomb][u32 valLen][val][u64 seq]
-func writeEntry(w *bufio.Writer, r memtable.Record) error {
-	var klenBuf [4]byte
-	binary.LittleEndian.PutUint32(klenBuf[:], uint32(len(r.Key)))
-	if _, err := w.Write(klenBuf[:]); err != nil {
-		return err
-	}
-	if _, err := w.Write(r.Key); err != nil {
-		return err
-	}
-	t := byte(0)
-	if r.Tombstone {
-		t = 1
-	}
-	if err := w.WriteByte(t); err != nil {
+// loadRangeDels reads the range-del block.
+func (t *Table) loadRangeDels(f *os.File, h blockHandle) error {
+	b, err := readBlock(f, h)
+	if err != nil {
 		return err
 	}
-	var vlenBuf [4]byte
-	binary.LittleEndian.PutUint32(vlenBuf[:], uint32(len(r.Value)))
-	if _, err := w.Write(vlenBuf[:]); err != nil {
+	bi, err := newBlockIter(b)
+	if err != nil {
 		return err
 	}
-	if _, err := w.Write(r.Value); err != nil {
-		return err
+	var ts []rangedel.Tombstone
+	for bi.First(); bi.Valid(); bi.Next() {
+		if bi.kind != kindRangeDelete {
+			return ErrCorrupt
+		}
+		ts = append(ts, rangedel.Tombstone{
+			Start: cloneBytes(bi.key),
+			End:   cloneBytes(bi.value),
+			Seq:   cmp.Or(t.globalSeq, bi.seq),
+		})
 	}
-	var seqBuf [8]byte
-	binary.LittleEndian.PutUint64(seqBuf[:], r.Seq)
-	if _, err := w.Write(seqBuf[:]); err != nil {
+	if err := bi.Err(); err != nil {
 		return err
 	}
+	t.rangeDels = rangedel.NewList(ts)
 	return nil
 }
 
-// Get looks for key in the table and returns the entry if found.
+// Get looks for key in the table and returns the newest entry if found.
 func (t *Table) Get(key []byte) (memtable.Record, bool, error) {
-	f, err := os.Open(t.Path)
-	if err != nil {
-		return memtable.Record{}, false, err
-	}
-	defer func() { _ = f.Close() }()
-
-	startOff, err := t.seekStartOffset(key)
-	if err != nil {
-		return memtable.Record{}, false, err
-	}
-
-	if _, err := f.Seek(int64(startOff), io.SeekStart); err != nil {
-		return memtable.Record{}, false, err
-	}
+	return t.GetAt(key, ^uint64(0))
+}
 
-	// Scan forward until key >= target or we hit the index section.
-	r := bufio.NewReaderSize(f, 64*1024)
-	for {
-		curOff, _ := f.Seek(0, io.SeekCurrent)
-		if uint64(curOff) >= t.indexOffset {
-			return memtable.Record{}, false, nil
-		}
-		rec, ok, err := readEntry(r)
-		if err != nil {
-			return memtable.Record{}, false, err
-		}
-		if !ok {
-			return memtable.Record{}, false, nil
+// GetAt returns the newest entry for key with Seq <= seq.
+func (t *Table) GetAt(key []byte, seq uint64) (memtable.Record, bool, error) {
+	// The file is only opened if a block isn't cached.
+	it := &Iterator{t: t, blk: -1}
+	defer func() { _ = it.Close() }()
+
+	// Versions of a key are stored newest first.
+	for it.SeekGE(key); it.Valid(); it.Next() {
+		rec := it.Record()
+		if !bytes.Equal(rec.Key, key) {
+			break
 		}
-		cmp := bytes.Compare(rec.Key, key)
-		if cmp == 0 {
+		if rec.Seq <= seq {
+			rec.Key = cloneBytes(rec.Key)
+			rec.Value = cloneBytes(rec.Value)
 			return rec, true, nil
 		}
-		if cmp > 0 {
-			return memtable.Record{}, false, nil
-		}
 	}
+	return memtable.Record{}, false, it.Err()
+}
+
+// MaxSeq returns the highest sequence number stored in the table.
+func (t *Table) MaxSeq() uint64 {
+	return t.maxSeq
 }
 
+// GlobalSeq returns the sequence number an ingested table's entries read
+// with, or 0.
+func (t *Table) GlobalSeq() uint64 {
+	return t.globalSeq
+}
+
+// Smallest returns the smallest key in the table or the smallest start of its
+// range tombstones, whichever is less (nil if the table is empty).
+func (t *Table) Smallest() []byte { return t.smallest }
+
+// Largest returns the largest key in the table or the largest end of its
+// range tombstones, whichever is greater (nil if the table is empty).
+func (t *Table) Largest() []byte { return t.largest }
+
+// RangeDels returns the table's range tombstones (nil if it has none).
+func (t *Table) RangeDels() *rangedel.List { return t.rangeDels }
+
+// Size returns the file size in bytes.
+func (t *Table) Size() int64 { return t.size }
+
 // MaybeContains checks the Bloom filter (if present).
 // If the table doesn't have a Bloom filter (older version), it returns true.
 func (t *Table) MaybeContains(key []byte) bool {
@@ -348,71 +332,80 @@ func (t *Table) MaybeContains(key []byte) bool {
 	return t.bf.MaybeContains(key)
 }
 
-func (t *Table) seekStartOffset(key []byte) (uint64, error) {
-	if len(t.index) == 0 {
-		return 0, nil
-	}
-	// Find last index entry with entry.key <= key.
-	lo, hi := 0, len(t.index)
-	for lo < hi {
-		mid := (lo + hi) / 2
-		if bytes.Compare(t.index[mid].key, key) <= 0 {
-			lo = mid + 1
-		} else {
-			hi = mid
-		}
-	}
-	i := lo - 1
-	if i < 0 {
-		return 0, nil
+// MaybeContainsPrefix reports whether the table may hold a key starting with
+// prefix, which must be a whole prefix as pe extracts it. Only a table whose
+// filter holds pe's prefixes can rule it out.
+func (t *Table) MaybeContainsPrefix(pe PrefixExtractor, prefix []byte) bool {
+	if t.bf == nil || pe == nil || t.prefixes != pe.Name() {
+		return true
 	}
-	return t.index[i].offset, nil
+	return t.bf.MaybeContains(prefix)
+}
+
+// blockFor returns the index of the block where a scan for key should start.
+// Versions of a key may spill over into the following blocks.
+func (t *Table) blockFor(key []byte) int {
+	if t.version == versionBlock {
+		// First block whose last key is >= key.
+		return sort.Search(len(t.index), func(i int) bool {
+			return bytes.Compare(t.index[i].key, key) >= 0
+		})
+	}
+	// Legacy segments are keyed by their first key: start at the last one
+	// whose first key is < key.
+	i := sort.Search(len(t.index), func(i int) bool {
+		return bytes.Compare(t.index[i].key, key) >= 0
+	})
+	if i > 0 {
+		i--
+	}
+	return i
 }
 
-func readEntry(r *bufio.Reader) (memtable.Record, bool, error) {
-	var klenBuf [4]byte
-	_, err := io.ReadFull(r, klenBuf[:])
+// acquire returns the table's file, from the file cache if there is one.
+func (t *Table) acquire() (*os.File, func(), error) {
+	if t.files != nil {
+		return t.files.Acquire(t.ID, t.Path)
+	}
+	f, err := os.Open(t.Path)
 	if err != nil {
-		if errors.Is(err, io.EOF) {
-			return memtable.Record{}, false, nil
+		return nil, nil, err
+	}
+	return f, func() { _ = f.Close() }, nil
+}
+
+// readCursor returns an iterator over the entries of block i, taking the
+// block from the cache when possible. open is only called on a miss.
+func (t *Table) readCursor(open func() (*os.File, error), i int) (cursor, error) {
+	h := t.index[i].handle
+	if t.version != versionBlock {
+		f, err := open()
+		if err != nil {
+			return nil, err
 		}
-		if errors.Is(err, io.ErrUnexpectedEOF) {
-			return memtable.Record{}, false, ErrCorrupt
+		recs, err := readLegacySegment(f, h)
+		if err != nil {
+			return nil, err
 		}
-		return memtable.Record{}, false, err
+		return &sliceIter{recs: recs, pos: -1}, nil
 	}
-	klen := binary.LittleEndian.Uint32(klenBuf[:])
-	if klen == 0 {
-		return memtable.Record{}, false, ErrCorrupt
+	if t.cache != nil {
+		if b, ok := t.cache.Get(t.ID, h.offset); ok {
+			return newBlockIter(b)
+		}
 	}
-	k := make([]byte, klen)
-	if _, err := io.ReadFull(r, k); err != nil {
-		return memtable.Record{}, false, ErrCorrupt
+	f, err := open()
+	if err != nil {
+		return nil, err
 	}
-	tomb, err := r.ReadByte()
+	b, err := readBlock(f, h)
 	if err != nil {
-		return memtable.Record{}, false, ErrCorrupt
-	}
-	var vlenBuf [4]byte
-	if _, err := io.ReadFull(r, vlenBuf[:]); err != nil {
-		return memtable.Record{}, false, ErrCorrupt
-	}
-	vlen := binary.LittleEndian.Uint32(vlenBuf[:])
-	v := make([]byte, vlen)
-	if _, err := io.ReadFull(r, v); err != nil {
-		return memtable.Record{}, false, ErrCorrupt
-	}
-	var seqBuf [8]byte
-	if _, err := io.ReadFull(r, seqBuf[:]); err != nil {
-		return memtable.Record{}, false, ErrCorrupt
-	}
-	seq := binary.LittleEndian.Uint64(seqBuf[:])
-	return memtable.Record{
-		Key:       k,
-		Value:     v,
-		Tombstone: tomb == 1,
-		Seq:       seq,
-	}, true, nil
+		return nil, err
+	}
+	if t.cache != nil {
+		t.cache.Set(t.ID, h.offset, b)
+	}
+	return newBlockIter(b)
 }
 
 func cloneBytes(b []byte) []byte {
@@ -425,4 +418,16 @@ func FormatFilename(id uint64) string {
 	return fmt.Sprintf("sstable-%06d.sst", id)
 }
 
-
+// ParseFilename returns the ID of an SSTable file name.
+func ParseFilename(name string) (uint64, bool) {
+	s, ok := strings.CutPrefix(name, "sstable-")
+	if !ok {
+		return 0, false
+	}
+	s, ok = strings.CutSuffix(s, ".sst")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
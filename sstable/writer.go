@@ -0,0 +1,269 @@
+package sstable
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"os"
+	"slices"
+
+	"github.com/ChinmayNoob/lsm-go/bloom"
+	"github.com/ChinmayNoob/lsm-go/memtable"
+	"github.com/ChinmayNoob/lsm-go/rangedel"
+)
+
+// ErrOutOfOrder is returned by Writer.Add for a record that doesn't sort
+// after the previous one.
+var ErrOutOfOrder = errors.New("sstable: records out of order")
+
+// WriterOptions configure a Writer.
+type WriterOptions struct {
+	// RestartInterval is the number of entries between restart points in a
+	// data block (16 if zero).
+	RestartInterval int
+	Compression     Compression
+	// PrefixExtractor, if set, adds each key's prefix to the Bloom filter
+	// (see Table.MaybeContainsPrefix).
+	PrefixExtractor PrefixExtractor
+}
+
+// Writer writes an SSTable one record at a time. Data blocks go to disk as
+// they fill up; only the index, the Bloom filter's key hashes and the range
+// tombstones are held until Finish.
+type Writer struct {
+	f    *os.File
+	w    *blockWriter
+	data *blockBuilder
+	idx  *blockBuilder
+	pe   PrefixExtractor
+
+	hashes     []bloom.KeyHash // one per distinct key and prefix
+	lastPrefix []byte
+	hasPrefix  bool
+	rangeDels  []rangedel.Tombstone
+	last       memtable.Record
+	hasLast    bool
+	maxSeq     uint64
+	buf        []byte // scratch for encoded values
+	err        error  // sticky
+}
+
+// NewWriter creates (or truncates) the file at path and returns a Writer
+// for it. The caller must call Finish or Abort.
+func NewWriter(path string, o WriterOptions) (*Writer, error) {
+	if o.RestartInterval <= 0 {
+		o.RestartInterval = 16
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		f:    f,
+		w:    &blockWriter{w: bufio.NewWriterSize(f, 64*1024), c: o.Compression},
+		data: newBlockBuilder(o.RestartInterval),
+		idx:  newBlockBuilder(1),
+		pe:   o.PrefixExtractor,
+	}, nil
+}
+
+// Add appends r. Records must come in internal order: key ascending, then
+// Seq descending.
+func (w *Writer) Add(r memtable.Record) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.hasLast && memtable.Compare(w.last, r) >= 0 {
+		return ErrOutOfOrder
+	}
+	if !w.hasLast || !bytes.Equal(w.last.Key, r.Key) {
+		w.hashes = append(w.hashes, bloom.Hash(r.Key))
+		w.addPrefix(r.Key)
+	}
+	kind, value := kindPut, r.Value
+	switch {
+	case r.Tombstone:
+		kind = kindDelete
+	case r.Merge:
+		kind = kindMerge
+	case r.ExpiresAt != 0:
+		kind = kindPutTTL
+		w.buf = binary.LittleEndian.AppendUint64(w.buf[:0], uint64(r.ExpiresAt))
+		w.buf = append(w.buf, r.Value...)
+		value = w.buf
+	}
+	w.data.add(r.Key, kind, r.Seq, value)
+	w.last.Key = append(w.last.Key[:0], r.Key...)
+	w.last.Seq = r.Seq
+	w.hasLast = true
+	w.maxSeq = max(w.maxSeq, r.Seq)
+	if w.data.estimatedSize() >= blockSize {
+		w.err = w.flushData()
+	}
+	return w.err
+}
+
+// addPrefix adds key's prefix to the filter, unless the previous key had the
+// same one: keys sharing a prefix are adjacent.
+func (w *Writer) addPrefix(key []byte) {
+	if w.pe == nil {
+		return
+	}
+	p, ok := w.pe.Prefix(key)
+	if !ok || (w.hasPrefix && bytes.Equal(p, w.lastPrefix)) {
+		return
+	}
+	w.hashes = append(w.hashes, bloom.Hash(p))
+	w.lastPrefix = append(w.lastPrefix[:0], p...)
+	w.hasPrefix = true
+}
+
+// AddRangeDel adds a range tombstone. Tombstones may come in any order and
+// need not be interleaved with Add.
+func (w *Writer) AddRangeDel(t rangedel.Tombstone) {
+	w.rangeDels = append(w.rangeDels, rangedel.Tombstone{
+		Start: cloneBytes(t.Start),
+		End:   cloneBytes(t.End),
+		Seq:   t.Seq,
+	})
+	w.maxSeq = max(w.maxSeq, t.Seq)
+}
+
+// Empty reports whether nothing has been added yet.
+func (w *Writer) Empty() bool {
+	return !w.hasLast && len(w.rangeDels) == 0
+}
+
+// EstimatedSize returns about how large the file is so far: the blocks
+// written plus the one being filled.
+func (w *Writer) EstimatedSize() int64 {
+	return int64(w.w.off) + int64(w.data.estimatedSize())
+}
+
+// flushData writes the current data block and indexes it by its last entry.
+func (w *Writer) flushData() error {
+	if w.data.empty() {
+		return nil
+	}
+	h, err := w.w.writeBlock(w.data.finish())
+	if err != nil {
+		return err
+	}
+	w.idx.add(w.data.lastKey, kindPut, w.data.lastSeq, h.encode())
+	w.data.reset()
+	return nil
+}
+
+// Finish writes the filter, range tombstones, meta and index blocks and the
+// footer, syncs the file and closes it. On error the file is left for the
+// caller to remove.
+func (w *Writer) Finish() error {
+	err := w.finish()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *Writer) finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flushData(); err != nil {
+		return err
+	}
+
+	bf := bloom.NewForKeys(len(w.hashes), 10, 7)
+	for _, h := range w.hashes {
+		bf.AddHash(h)
+	}
+	filterH, err := w.w.writeBlock(bf.Encode())
+	if err != nil {
+		return err
+	}
+	var rangeDelH *blockHandle
+	if len(w.rangeDels) > 0 {
+		slices.SortFunc(w.rangeDels, func(a, b rangedel.Tombstone) int {
+			if c := bytes.Compare(a.Start, b.Start); c != 0 {
+				return c
+			}
+			return cmp.Compare(b.Seq, a.Seq)
+		})
+		rb := newBlockBuilder(16)
+		for _, ts := range w.rangeDels {
+			rb.add(ts.Start, kindRangeDelete, ts.Seq, ts.End)
+		}
+		h, err := w.w.writeBlock(rb.finish())
+		if err != nil {
+			return err
+		}
+		rangeDelH = &h
+	}
+	meta := newBlockBuilder(1)
+	meta.add([]byte("filter"), kindPut, 0, filterH.encode())
+	meta.add([]byte("max-seq"), kindPut, 0, binary.AppendUvarint(nil, w.maxSeq))
+	if w.pe != nil {
+		meta.add([]byte("prefix-extractor"), kindPut, 0, []byte(w.pe.Name()))
+	}
+	if rangeDelH != nil {
+		meta.add([]byte("range-del"), kindPut, 0, rangeDelH.encode())
+	}
+	metaH, err := w.w.writeBlock(meta.finish())
+	if err != nil {
+		return err
+	}
+	indexH, err := w.w.writeBlock(w.idx.finish())
+	if err != nil {
+		return err
+	}
+
+	var footer [footerSizeV3]byte
+	binary.LittleEndian.PutUint64(footer[0:8], metaH.offset)
+	binary.LittleEndian.PutUint64(footer[8:16], metaH.size)
+	binary.LittleEndian.PutUint64(footer[16:24], indexH.offset)
+	binary.LittleEndian.PutUint64(footer[24:32], indexH.size)
+	binary.LittleEndian.PutUint32(footer[32:36], magic)
+	binary.LittleEndian.PutUint16(footer[36:38], versionBlock)
+	if _, err := w.w.w.Write(footer[:]); err != nil {
+		return err
+	}
+	if err := w.w.w.Flush(); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// Abort closes the file and removes it.
+func (w *Writer) Abort() {
+	_ = w.f.Close()
+	_ = os.Remove(w.f.Name())
+}
+
+// blockWriter appends blocks with their trailers and tracks the file offset
+// itself, since f.Seek would not see bytes still buffered in w.
+type blockWriter struct {
+	w   *bufio.Writer
+	c   Compression
+	off uint64
+}
+
+func (bw *blockWriter) writeBlock(raw []byte) (blockHandle, error) {
+	b, typ, err := compressBlock(bw.c, raw)
+	if err != nil {
+		return blockHandle{}, err
+	}
+	h := blockHandle{offset: bw.off, size: uint64(len(b))}
+	var trailer [blockTrailerLen]byte
+	trailer[0] = typ
+	binary.LittleEndian.PutUint32(trailer[1:], blockChecksum(b, trailer[0]))
+	if _, err := bw.w.Write(b); err != nil {
+		return blockHandle{}, err
+	}
+	if _, err := bw.w.Write(trailer[:]); err != nil {
+		return blockHandle{}, err
+	}
+	bw.off += uint64(len(b)) + blockTrailerLen
+	return h, nil
+}
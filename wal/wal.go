@@ -4,8 +4,12 @@ import (
 	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 )
 
 type Op uint8
@@ -13,9 +17,88 @@ type Op uint8
 const (
 	OpPut    Op = 1
 	OpDelete Op = 2
+	// OpBatch frames several puts/deletes with consecutive sequence numbers
+	// as one record, so replay applies all of them or none.
+	OpBatch Op = 3
+	// OpRangeDelete deletes the keys in [Key, Value): the record's Value
+	// holds the exclusive end of the range.
+	OpRangeDelete Op = 4
+	// OpPutTTL is how a put with an ExpiresAt is stored: its value is
+	// [u64 expiresAt][value]. Replay returns it as an OpPut.
+	OpPutTTL Op = 5
+	// OpMerge records a merge operand for Key in Value.
+	OpMerge Op = 6
 )
 
-var ErrCorrupt = errors.New("corrupt wal")
+func (op Op) valid() bool {
+	return op == OpPut || op == OpDelete || op == OpRangeDelete || op == OpPutTTL || op == OpMerge
+}
+
+// stored returns the op and value r is written with.
+func (r Record) stored() (Op, []byte) {
+	if r.Op != OpPut || r.ExpiresAt == 0 {
+		return r.Op, r.Value
+	}
+	v := binary.LittleEndian.AppendUint64(make([]byte, 0, 8+len(r.Value)), uint64(r.ExpiresAt))
+	return OpPutTTL, append(v, r.Value...)
+}
+
+// fromStored turns a record as written back into the one it was written
+// from (see stored).
+func fromStored(r Record) (Record, error) {
+	if r.Op != OpPutTTL {
+		return r, nil
+	}
+	if len(r.Value) < 8 {
+		return Record{}, ErrCorrupt
+	}
+	r.Op = OpPut
+	r.ExpiresAt = int64(binary.LittleEndian.Uint64(r.Value))
+	r.Value = r.Value[8:]
+	return r, nil
+}
+
+// Records are framed as [u32 len][payload]. Since format v2 the payload is
+// [u8 checksumMarker][u32 crc32c(body)][body]; v1 payloads are the bare body
+// and start with an Op, which never equals the marker, so logs written before
+// checksums still replay.
+const checksumMarker byte = 0xff
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	ErrCorrupt   = errors.New("corrupt wal")
+	ErrTruncated = errors.New("truncated wal")
+)
+
+// CorruptionError is returned by Replay when it hits a bad record.
+// Truncated means the damage is confined to the end of the log, which is what
+// a write torn by a crash looks like: no intact record follows it. The
+// records before Offset are fine and the log can be cut there. Otherwise
+// bytes in the middle of the log are bad, and cutting would lose the records
+// after them.
+//
+// errors.Is matches ErrTruncated or ErrCorrupt accordingly.
+type CorruptionError struct {
+	Offset    int64 // start of the bad record
+	Truncated bool
+	Reason    string
+}
+
+func (e *CorruptionError) Error() string {
+	kind := "corrupt"
+	if e.Truncated {
+		kind = "truncated"
+	}
+	return fmt.Sprintf("wal: %s record at offset %d: %s", kind, e.Offset, e.Reason)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	if e.Truncated {
+		return ErrTruncated
+	}
+	return ErrCorrupt
+}
 
 type WAL struct {
 	f           *os.File
@@ -52,33 +135,50 @@ func (w *WAL) Close() error {
 	return nil
 }
 
-func (w *WAL) Append(op Op, seq uint64, key, value []byte) error {
-	if w == nil || w.f == nil {
-		return errors.New("wal is closed")
+// AppendBatch writes recs as a single record. The i-th entry gets sequence
+// number seq+i; the Seq field of recs is ignored.
+func (w *WAL) AppendBatch(seq uint64, recs []Record) error {
+	// [u8 OpBatch][u64 seq][u32 count] then per entry
+	// [u8 op][u32 keyLen][u32 valLen][key][val]
+	size := 1 + 8 + 4
+	for _, r := range recs {
+		size += 1 + 4 + 4 + len(r.Key) + len(r.Value)
+		if r.ExpiresAt != 0 {
+			size += 8
+		}
 	}
+	buf := make([]byte, 1+8+4, size)
+	buf[0] = byte(OpBatch)
+	binary.LittleEndian.PutUint64(buf[1:9], seq)
+	binary.LittleEndian.PutUint32(buf[9:13], uint32(len(recs)))
+	for _, r := range recs {
+		op, value := r.stored()
+		var hdr [1 + 4 + 4]byte
+		hdr[0] = byte(op)
+		binary.LittleEndian.PutUint32(hdr[1:5], uint32(len(r.Key)))
+		binary.LittleEndian.PutUint32(hdr[5:9], uint32(len(value)))
+		buf = append(buf, hdr[:]...)
+		buf = append(buf, r.Key...)
+		buf = append(buf, value...)
+	}
+	return w.writeRecord(buf)
+}
 
-	keyLen := uint32(len(key))
-	valLen := uint32(len(value))
-	recLen := 1 + 8 + 4 + 4 + int(keyLen) + int(valLen)
-
-	var lenBuf [4]byte
-	binary.LittleEndian.PutUint32(lenBuf[:], uint32(recLen))
-	if _, err := w.w.Write(lenBuf[:]); err != nil {
-		return err
+// writeRecord writes [u32 len][u8 checksumMarker][u32 crc][body] and flushes
+// (and fsyncs, if enabled).
+func (w *WAL) writeRecord(body []byte) error {
+	if w == nil || w.f == nil {
+		return errors.New("wal is closed")
 	}
 
-	var hdr [1 + 8 + 4 + 4]byte
-	hdr[0] = byte(op)
-	binary.LittleEndian.PutUint64(hdr[1:9], seq)
-	binary.LittleEndian.PutUint32(hdr[9:13], keyLen)
-	binary.LittleEndian.PutUint32(hdr[13:17], valLen)
+	var hdr [4 + 1 + 4]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(1+4+len(body)))
+	hdr[4] = checksumMarker
+	binary.LittleEndian.PutUint32(hdr[5:9], crc32.Checksum(body, crcTable))
 	if _, err := w.w.Write(hdr[:]); err != nil {
 		return err
 	}
-	if _, err := w.w.Write(key); err != nil {
-		return err
-	}
-	if _, err := w.w.Write(value); err != nil {
+	if _, err := w.w.Write(body); err != nil {
 		return err
 	}
 
@@ -89,17 +189,23 @@ func (w *WAL) Append(op Op, seq uint64, key, value []byte) error {
 		return w.f.Sync()
 	}
 	return nil
-
 }
 
-
 type Record struct {
 	Op    Op
 	Seq   uint64
 	Key   []byte
 	Value []byte
+	// ExpiresAt is when a put's value expires, in Unix nanoseconds; 0 if
+	// it never does.
+	ExpiresAt int64
 }
 
+// Replay calls fn for every record in the log, in order, and returns the
+// highest sequence number seen. A missing log replays nothing.
+//
+// On a bad record it stops and returns a *CorruptionError; see there for how
+// a torn tail is told apart from corruption in the middle.
 func Replay(path string, fn func(Record) error) (maxSeq uint64, err error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -110,50 +216,133 @@ func Replay(path string, fn func(Record) error) (maxSeq uint64, err error) {
 	}
 	defer func() { _ = f.Close() }()
 
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := st.Size()
+
 	r := bufio.NewReaderSize(f, 64*1024)
+	var off int64
 	for {
 		var lenBuf [4]byte
 		_, err := io.ReadFull(r, lenBuf[:])
 		if err != nil {
+			// If we got a clean EOF at boundary, ReadFull returns EOF above.
 			if errors.Is(err, io.EOF) {
 				return maxSeq, nil
 			}
-			// If we got a clean EOF at boundary, ReadFull returns EOF above.
 			if errors.Is(err, io.ErrUnexpectedEOF) {
-				// Ignore trailing partial length prefix (common after crash).
-				return maxSeq, nil
+				return maxSeq, &CorruptionError{Offset: off, Truncated: true, Reason: "partial length prefix"}
 			}
 			return maxSeq, err
 		}
 		recLen := binary.LittleEndian.Uint32(lenBuf[:])
+		end := off + 4 + int64(recLen)
 		if recLen == 0 {
-			return maxSeq, ErrCorrupt
+			// Some filesystems zero-fill the tail of a file after a crash.
+			if zeros, err := onlyZeros(r); err != nil {
+				return maxSeq, err
+			} else if zeros {
+				return maxSeq, &CorruptionError{Offset: off, Truncated: true, Reason: "zero-filled tail"}
+			}
+			return maxSeq, &CorruptionError{Offset: off, Reason: "zero length"}
+		}
+		if end > size {
+			// A torn write, or a damaged length in the middle of the log.
+			return maxSeq, tailError(f, off, size, "partial record")
 		}
 		rec := make([]byte, recLen)
 		if _, err := io.ReadFull(r, rec); err != nil {
-			// Ignore trailing partial record (common after crash).
 			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
-				return maxSeq, nil
+				return maxSeq, tailError(f, off, size, "partial record")
 			}
 			return maxSeq, err
 		}
-		rr, err := decodeRecord(rec)
+
+		body := rec
+		if rec[0] == checksumMarker {
+			if len(rec) < 1+4 {
+				return maxSeq, &CorruptionError{Offset: off, Reason: "short checksummed record"}
+			}
+			body = rec[5:]
+			if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(rec[1:5]) {
+				// Only the last record can be a torn write; a bad checksum
+				// with more records after it is real corruption.
+				if end < size {
+					return maxSeq, &CorruptionError{Offset: off, Reason: "checksum mismatch"}
+				}
+				return maxSeq, tailError(f, off, size, "checksum mismatch")
+			}
+		}
+		// A batch is fully decoded before fn sees any of it.
+		rrs, err := decodeRecord(body)
 		if err != nil {
-			return maxSeq, err
+			return maxSeq, &CorruptionError{Offset: off, Reason: "malformed record"}
 		}
-		if rr.Seq > maxSeq {
-			maxSeq = rr.Seq
+		for _, rr := range rrs {
+			if rr.Seq > maxSeq {
+				maxSeq = rr.Seq
+			}
+			if err := fn(rr); err != nil {
+				return maxSeq, err
+			}
 		}
-		if err := fn(rr); err != nil {
-			return maxSeq, err
+		off = end
+	}
+}
+
+// tailError returns the error for a bad record at off that seems to run to
+// the end of the log. It is a torn tail unless an intact checksummed record
+// starts somewhere after off: then a damaged length hid the records behind
+// it, and cutting the log would lose them.
+func tailError(f *os.File, off, size int64, reason string) error {
+	tail := make([]byte, size-off)
+	if _, err := f.ReadAt(tail, off); err != nil {
+		return err
+	}
+	for i := 1; i+4+1+4 <= len(tail); i++ {
+		if intactFrame(tail[i:]) {
+			return &CorruptionError{Offset: off, Reason: fmt.Sprintf("%s, intact record at offset %d", reason, off+int64(i))}
 		}
 	}
+	return &CorruptionError{Offset: off, Truncated: true, Reason: reason}
 }
 
-func decodeRecord(b []byte) (Record, error) {
+// intactFrame reports whether b starts with a checksummed record whose
+// checksum matches.
+func intactFrame(b []byte) bool {
+	n := int64(binary.LittleEndian.Uint32(b))
+	if n < 1+4 || n > int64(len(b))-4 || b[4] != checksumMarker {
+		return false
+	}
+	return crc32.Checksum(b[9:4+n], crcTable) == binary.LittleEndian.Uint32(b[5:9])
+}
+
+// onlyZeros reports whether r has nothing but zero bytes left.
+func onlyZeros(r *bufio.Reader) (bool, error) {
+	for {
+		b, err := r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b != 0 {
+			return false, nil
+		}
+	}
+}
+
+func decodeRecord(b []byte) ([]Record, error) {
+	if len(b) > 0 && Op(b[0]) == OpBatch {
+		return decodeBatch(b)
+	}
+	// A single op, as logs written before batches hold them:
 	// [u8 op][u64 seq][u32 keyLen][u32 valLen][key][val]
 	if len(b) < 1+8+4+4 {
-		return Record{}, ErrCorrupt
+		return nil, ErrCorrupt
 	}
 	op := Op(b[0])
 	seq := binary.LittleEndian.Uint64(b[1:9])
@@ -161,7 +350,7 @@ func decodeRecord(b []byte) (Record, error) {
 	valLen := binary.LittleEndian.Uint32(b[13:17])
 	need := 1 + 8 + 4 + 4 + int(keyLen) + int(valLen)
 	if len(b) != need {
-		return Record{}, ErrCorrupt
+		return nil, ErrCorrupt
 	}
 	keyStart := 17
 	keyEnd := keyStart + int(keyLen)
@@ -170,8 +359,68 @@ func decodeRecord(b []byte) (Record, error) {
 	copy(key, b[keyStart:keyEnd])
 	val := make([]byte, valLen)
 	copy(val, b[keyEnd:valEnd])
-	if op != OpPut && op != OpDelete {
-		return Record{}, ErrCorrupt
+	if !op.valid() {
+		return nil, ErrCorrupt
+	}
+	r, err := fromStored(Record{Op: op, Seq: seq, Key: key, Value: val})
+	if err != nil {
+		return nil, err
 	}
-	return Record{Op: op, Seq: seq, Key: key, Value: val}, nil
-}
\ No newline at end of file
+	return []Record{r}, nil
+}
+
+func decodeBatch(b []byte) ([]Record, error) {
+	// [u8 OpBatch][u64 seq][u32 count] then per entry
+	// [u8 op][u32 keyLen][u32 valLen][key][val]
+	if len(b) < 1+8+4 {
+		return nil, ErrCorrupt
+	}
+	seq := binary.LittleEndian.Uint64(b[1:9])
+	count := binary.LittleEndian.Uint32(b[9:13])
+	b = b[13:]
+	recs := make([]Record, 0, min(int(count), len(b)/9))
+	for i := uint32(0); i < count; i++ {
+		if len(b) < 1+4+4 {
+			return nil, ErrCorrupt
+		}
+		op := Op(b[0])
+		keyLen := int(binary.LittleEndian.Uint32(b[1:5]))
+		valLen := int(binary.LittleEndian.Uint32(b[5:9]))
+		if !op.valid() {
+			return nil, ErrCorrupt
+		}
+		b = b[9:]
+		if keyLen > len(b) || valLen > len(b)-keyLen {
+			return nil, ErrCorrupt
+		}
+		key := make([]byte, keyLen)
+		copy(key, b[:keyLen])
+		val := make([]byte, valLen)
+		copy(val, b[keyLen:keyLen+valLen])
+		b = b[keyLen+valLen:]
+		r, err := fromStored(Record{Op: op, Seq: seq + uint64(i), Key: key, Value: val})
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, r)
+	}
+	if len(b) != 0 {
+		return nil, ErrCorrupt
+	}
+	return recs, nil
+}
+
+// FormatFilename returns the name of WAL file num.
+func FormatFilename(num uint64) string {
+	return fmt.Sprintf("%06d.log", num)
+}
+
+// ParseFilename returns the number of a WAL file name.
+func ParseFilename(name string) (uint64, bool) {
+	s, ok := strings.CutSuffix(name, ".log")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
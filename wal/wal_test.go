@@ -0,0 +1,144 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeLog writes n single-put records and returns the log's path and the
+// offset each record starts at.
+func writeLog(t *testing.T, n int) (string, []int64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "wal.log")
+	w, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		rec := Record{Op: OpPut, Key: fmt.Appendf(nil, "k%03d", i), Value: []byte("value")}
+		if err := w.AppendBatch(uint64(i+1), []Record{rec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offs []int64
+	for off := int64(0); off < int64(len(b)); off += 4 + int64(binary.LittleEndian.Uint32(b[off:])) {
+		offs = append(offs, off)
+	}
+	return path, offs
+}
+
+// damage applies fn to the log's bytes.
+func damage(t *testing.T, path string, fn func([]byte) []byte) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, fn(b), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func replayAll(path string) (int, error) {
+	n := 0
+	_, err := Replay(path, func(Record) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func TestReplayCleanEOF(t *testing.T) {
+	path, _ := writeLog(t, 10)
+	n, err := replayAll(path)
+	if err != nil || n != 10 {
+		t.Fatalf("replayed %d, err %v; want 10, nil", n, err)
+	}
+}
+
+func TestReplayCorruption(t *testing.T) {
+	const records = 20
+	tests := []struct {
+		name      string
+		bad       int // record the damage is in
+		damage    func(b []byte, offs []int64) []byte
+		truncated bool
+	}{
+		{
+			name: "partial tail",
+			bad:  records - 1,
+			damage: func(b []byte, offs []int64) []byte {
+				return b[:len(b)-3]
+			},
+			truncated: true,
+		},
+		{
+			name: "checksum mismatch on last record",
+			bad:  records - 1,
+			damage: func(b []byte, offs []int64) []byte {
+				b[len(b)-1] ^= 0x01
+				return b
+			},
+			truncated: true,
+		},
+		{
+			name: "checksum mismatch in the middle",
+			bad:  10,
+			damage: func(b []byte, offs []int64) []byte {
+				b[offs[11]-1] ^= 0x01
+				return b
+			},
+		},
+		{
+			name: "length past end of file in the middle",
+			bad:  10,
+			damage: func(b []byte, offs []int64) []byte {
+				b[offs[10]+2] = 0x7f
+				return b
+			},
+		},
+		{
+			name: "length too short in the middle",
+			bad:  10,
+			damage: func(b []byte, offs []int64) []byte {
+				b[offs[10]] -= 3
+				return b
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, offs := writeLog(t, records)
+			damage(t, path, func(b []byte) []byte { return tt.damage(b, offs) })
+			n, err := replayAll(path)
+			var cerr *CorruptionError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("err = %v, want a *CorruptionError", err)
+			}
+			if cerr.Truncated != tt.truncated {
+				t.Fatalf("Truncated = %v, want %v (%v)", cerr.Truncated, tt.truncated, err)
+			}
+			if cerr.Offset != offs[tt.bad] || n != tt.bad {
+				t.Fatalf("stopped at offset %d after %d records, want %d after %d", cerr.Offset, n, offs[tt.bad], tt.bad)
+			}
+			want := ErrCorrupt
+			if tt.truncated {
+				want = ErrTruncated
+			}
+			if !errors.Is(err, want) {
+				t.Fatalf("errors.Is(%v, %v) = false", err, want)
+			}
+		})
+	}
+}